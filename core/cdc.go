@@ -0,0 +1,101 @@
+package core
+
+import (
+	"errors"
+	"math/bits"
+)
+
+// chunkCutter findet die Chunkgrenzen in einem Datenstrom.
+type chunkCutter interface {
+	// cut gibt zurück, wie viele bytes aus data noch zum aktuellen Chunk gehören, wenn er in data endet.
+	// Endet der Chunk nicht in data, dann wird -1 zurück gegeben. Der Zustand bleibt über mehrere Aufrufe erhalten.
+	cut(data []byte) int
+}
+
+// fixedCutter teilt in Chunks mit fester Größe (CHUNKSIZE).
+type fixedCutter struct {
+	size int
+	pos  int // bytes im aktuellen Chunk
+}
+
+func (c *fixedCutter) cut(data []byte) int {
+	remaining := c.size - c.pos
+	if len(data) < remaining {
+		c.pos += len(data)
+		return -1
+	}
+	c.pos = 0
+	return remaining
+}
+
+// cdcCutter teilt nach dem Inhalt (content-defined chunking, FastCDC).
+// Über die Daten wird ein Gear-Hash gebildet. Eine Chunkgrenze ist dort, wo die oberen Bits des Hash null sind.
+// Vor avgSize wird eine strengere Maske verwendet, danach eine schwächere (normalized chunking).
+// Dadurch verschieben sich nach einer Einfügung am Dateianfang nur die ersten Chunks, der Rest bleibt gleich.
+type cdcCutter struct {
+	minSize, avgSize, maxSize int
+	maskS, maskL              uint64
+	pos                       int    // bytes im aktuellen Chunk
+	hash                      uint64 // Gear-Hash
+}
+
+// Die Gear-Tabelle darf sich NIE ändern, sonst ändern sich alle Chunkgrenzen (und damit alle Chunks).
+// Sie wird mit splitmix64 aus einem festen Startwert erzeugt.
+var gearTable = func() (t [256]uint64) {
+	x := uint64(0x53504c4954465553) // "SPLITFUS"
+	for i := range t {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		t[i] = z ^ (z >> 31)
+	}
+	return
+}()
+
+func newCdcCutter(cfg ScanConfig) *cdcCutter {
+	b := bits.Len64(cfg.AvgSize) - 1 // log2(avgSize)
+	return &cdcCutter{
+		minSize: int(cfg.MinSize),
+		avgSize: int(cfg.AvgSize),
+		maxSize: int(cfg.MaxSize),
+		maskS:   ^uint64(0) << uint(64-(b+2)),
+		maskL:   ^uint64(0) << uint(64-(b-2)),
+	}
+}
+
+func (c *cdcCutter) cut(data []byte) int {
+	for i, b := range data {
+		c.pos++
+		// die ersten minSize bytes werden nicht betrachtet
+		if c.pos <= c.minSize {
+			continue
+		}
+		c.hash = (c.hash << 1) + gearTable[b]
+
+		mask := c.maskS
+		if c.pos > c.avgSize {
+			mask = c.maskL
+		}
+		if c.hash&mask == 0 || c.pos >= c.maxSize {
+			c.pos = 0
+			c.hash = 0
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// checkCdcSizes prüft die Größen für das content-defined chunking.
+func checkCdcSizes(minSize, avgSize, maxSize uint64) error {
+	if minSize < 64 || maxSize > CHUNKSIZE {
+		return errors.New("cdc chunk sizes must be between 64 bytes and CHUNKSIZE")
+	}
+	if avgSize&(avgSize-1) != 0 || avgSize < 256 {
+		return errors.New("cdc average chunk size must be a power of two (at least 256 bytes)")
+	}
+	if minSize > avgSize || avgSize > maxSize {
+		return errors.New("cdc chunk sizes must be min <= avg <= max")
+	}
+	return nil
+}
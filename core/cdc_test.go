@@ -0,0 +1,158 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// kleine Chunks, damit die Tests schnell sind
+var testCdcConfig = ScanConfig{Chunking: ChunkingCDC, MinSize: 1024, AvgSize: 4096, MaxSize: 16384}
+
+// scannt data mit content-defined chunking
+func scanTestData(t *testing.T, data []byte) SfFile {
+	file, _ := ioutil.TempFile("", "cdc.test")
+	file.Write(data)
+	file.Close()
+	defer os.Remove(file.Name())
+
+	f, err := scanFile(file.Name(), testCdcConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestScanFileCdc(t *testing.T) {
+	data := make([]byte, 300000)
+	rand.New(rand.NewSource(1)).Read(data)
+	f := scanTestData(t, data)
+
+	if len(f.FileChunks) < 20 || len(f.ChunkOffsets) != len(f.FileChunks) {
+		t.Fatalf("wrong chunk count: %d chunks, %d offsets", len(f.FileChunks), len(f.ChunkOffsets))
+	}
+
+	// Grenzen und Hashes prüfen
+	var next uint64
+	for i, h := range f.FileChunks {
+		offset, size := f.ChunkBounds(i)
+		if offset != next {
+			t.Errorf("chunk %d: offset %d, expected %d", i, offset, next)
+		}
+		if size > testCdcConfig.MaxSize || (size < testCdcConfig.MinSize && i < len(f.FileChunks)-1) {
+			t.Errorf("chunk %d: wrong size %d", i, size)
+		}
+		if sum := sha512.Sum512(data[offset : offset+size]); !bytes.Equal(sum[:], h[:]) {
+			t.Errorf("chunk %d: wrong hash", i)
+		}
+		if nr, chunkOffset := f.ChunkAt(offset + size - 1); nr != i || chunkOffset != size-1 {
+			t.Errorf("ChunkAt(%d): %d, %d", offset+size-1, nr, chunkOffset)
+		}
+		next = offset + size
+	}
+	if next != f.Size {
+		t.Errorf("chunks end at %d, file size is %d", next, f.Size)
+	}
+	if nr, _ := f.ChunkAt(f.Size); nr != len(f.FileChunks) {
+		t.Errorf("ChunkAt(size) should be the chunk count: %d", nr)
+	}
+}
+
+// Ein eingefügtes byte am Anfang darf nur die ersten Chunks verändern.
+func TestScanFileCdcShift(t *testing.T) {
+	data := make([]byte, 300000)
+	rand.New(rand.NewSource(2)).Read(data)
+	f1 := scanTestData(t, data)
+	f2 := scanTestData(t, append([]byte{42}, data...))
+
+	known := make(map[ChunkHash]bool)
+	for _, h := range f1.FileChunks {
+		known[h] = true
+	}
+	same := 0
+	for _, h := range f2.FileChunks {
+		if known[h] {
+			same++
+		}
+	}
+	if same < len(f1.FileChunks)-2 {
+		t.Errorf("only %d of %d chunks are unchanged", same, len(f1.FileChunks))
+	}
+}
+
+// Wird das Chunking geändert, dann werden alle Dateien neu gescannt.
+func TestScanFolderChunkingChange(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "cdc.test")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "a.txt"), bytes.Repeat([]byte("abc"), 10000), 0600)
+
+	db, _, _, err := ScanFolder(dir, SfDb{}, false)
+	if err != nil || db["a.txt"].ChunkOffsets != nil {
+		t.Fatalf("fixed scan: %v", err)
+	}
+	db, changed, _, err := ScanFolderWithConfig(dir, db, testCdcConfig, false)
+	if err != nil || !changed || db["a.txt"].ChunkOffsets == nil {
+		t.Errorf("cdc scan: changed=%v, err=%v", changed, err)
+	}
+	_, changed, _, _ = ScanFolderWithConfig(dir, db, testCdcConfig, false)
+	if changed {
+		t.Errorf("second cdc scan should not change anything")
+	}
+
+	// ungültige Einstellungen
+	bad := testCdcConfig
+	bad.AvgSize = 5000
+	if _, _, _, err := ScanFolderWithConfig(dir, db, bad, false); err == nil {
+		t.Errorf("avg size must be a power of two")
+	}
+}
+
+// Feste Chunkgröße, die nicht CHUNKSIZE ist
+func TestScanFileChunkSize(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "chunksize.test")
+	defer os.RemoveAll(dir)
+	data := make([]byte, 600000)
+	rand.New(rand.NewSource(4)).Read(data)
+	ioutil.WriteFile(filepath.Join(dir, "a.bin"), data, 0600)
+
+	cfg := DefaultScanConfig()
+	cfg.ChunkSize = 262144
+	db, _, _, err := ScanFolderWithConfig(dir, SfDb{}, cfg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := db["a.bin"]
+	if len(f.FileChunks) != 3 || f.ChunkSize != 262144 || f.ChunkOffsets != nil {
+		t.Fatalf("wrong chunks: %d, %d", len(f.FileChunks), f.ChunkSize)
+	}
+	if offset, size := f.ChunkBounds(2); offset != 524288 || size != 600000-524288 {
+		t.Errorf("ChunkBounds(2): %d, %d", offset, size)
+	}
+	if nr, chunkOffset := f.ChunkAt(262145); nr != 1 || chunkOffset != 1 {
+		t.Errorf("ChunkAt: %d, %d", nr, chunkOffset)
+	}
+	sum := sha512.Sum512(data[262144:524288])
+	if !bytes.Equal(sum[:], f.FileChunks[1][:]) {
+		t.Errorf("wrong hash of chunk 1")
+	}
+
+	// andere Chunkgröße: neu scannen
+	_, changed, _, _ := ScanFolderWithConfig(dir, db, cfg, false)
+	cfg.ChunkSize = CHUNKSIZE
+	db2, changed2, _, _ := ScanFolderWithConfig(dir, db, cfg, false)
+	if changed || !changed2 || len(db2["a.bin"].FileChunks) != 1 || db2["a.bin"].ChunkSize != 0 {
+		t.Errorf("chunk size change: changed=%v, changed2=%v", changed, changed2)
+	}
+
+	// ungültige Chunkgrößen
+	for _, size := range []uint64{0, 100000, 131072 + 16} {
+		cfg.ChunkSize = size
+		if _, _, _, err := ScanFolderWithConfig(dir, db, cfg, false); err == nil {
+			t.Errorf("chunk size %d should fail", size)
+		}
+	}
+}
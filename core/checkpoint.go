@@ -0,0 +1,177 @@
+package core
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Ein Checkpoint-Datei beginnt mit diesem Kopf (wird als additional data authentisiert),
+// danach folgen nonce und ciphertext wie bei der DB.
+const (
+	checkpointMagic   = "SFCP"
+	checkpointVersion = 1
+)
+
+// CheckpointConfig schaltet das Speichern von Checkpoints während dem Scan ein.
+type CheckpointConfig struct {
+	Path     string        // Checkpoint-Datei (siehe CheckpointPath)
+	Key      []byte        // Schlüssel der DB
+	Interval time.Duration // so oft wird der Checkpoint höchstens geschrieben
+}
+
+// Checkpoint enthält die Dateien, die ein abgebrochener Scan schon gehasht hat.
+type Checkpoint struct {
+	Root  string    // absoluter Pfad zum gescannten Ordner
+	Files SfDb      // gehashte Dateien (relativer Pfad -> Eintrag)
+	Bytes uint64    // Größe aller gehashten Dateien
+	Time  time.Time // Zeitpunkt des Checkpoints
+}
+
+// CheckpointPath gibt den Pfad zur Checkpoint-Datei einer DB zurück.
+func CheckpointPath(dbpath string) string {
+	return dbpath + ".checkpoint"
+}
+
+// WriteCheckpoint verschlüsselt den Checkpoint mit key und schreibt ihn (wie WriteDbFile) nach path.
+func WriteCheckpoint(path string, key []byte, cp Checkpoint) error {
+	header := append([]byte(checkpointMagic), checkpointVersion)
+	nonce, ciphertext, err := sealGOB(key, cp, header)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, false, header, nonce, ciphertext)
+}
+
+// CheckpointInvalidError wird zurück gegeben, wenn eine Checkpoint-Datei gelesen werden konnte,
+// aber kein gültiger Checkpoint ist (z.B. abgeschnitten oder mit einem anderen Schlüssel verschlüsselt).
+type CheckpointInvalidError struct {
+	Path   string
+	Reason string
+}
+
+func (e *CheckpointInvalidError) Error() string {
+	return "invalid checkpoint " + e.Path + ": " + e.Reason
+}
+
+// IsCheckpointInvalid prüft, ob der Fehler ein CheckpointInvalidError ist.
+func IsCheckpointInvalid(err error) bool {
+	_, ok := err.(*CheckpointInvalidError)
+	return ok
+}
+
+// ReadCheckpoint liest einen Checkpoint. Gibt es keine Datei, dann wird nil zurück gegeben.
+// Passen Format oder Authentisierung nicht, dann wird ein CheckpointInvalidError zurück gegeben,
+// Lesefehler werden unverändert zurück gegeben.
+func ReadCheckpoint(path string, key []byte) (*Checkpoint, error) {
+	gcmStandardNonceSize := 12
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	headerSize := len(checkpointMagic) + 1
+	if len(data) < headerSize+gcmStandardNonceSize || !bytes.HasPrefix(data, []byte(checkpointMagic)) {
+		return nil, &CheckpointInvalidError{Path: path, Reason: "not a checkpoint file"}
+	}
+	if data[len(checkpointMagic)] != checkpointVersion {
+		return nil, &CheckpointInvalidError{Path: path, Reason: "unknown version"}
+	}
+	cp := &Checkpoint{}
+	err = openGOB(key, data[headerSize:headerSize+gcmStandardNonceSize], data[headerSize+gcmStandardNonceSize:], data[:headerSize], cp)
+	if err != nil {
+		return nil, &CheckpointInvalidError{Path: path, Reason: err.Error()}
+	}
+	return cp, nil
+}
+
+// checkpointer sammelt die Ergebnisse eines Scans und schreibt sie regelmäßig als Checkpoint.
+type checkpointer struct {
+	cfg    *CheckpointConfig
+	resume SfDb // Dateien aus dem vorherigen Checkpoint
+
+	mux  sync.Mutex
+	cp   Checkpoint
+	last time.Time
+	err  error // erster Fehler beim Schreiben
+}
+
+// newCheckpointer lädt den vorherigen Checkpoint (wenn er zum selben Ordner gehört).
+// Ein ungültiger Checkpoint (Format oder Schlüssel falsch) wird gelöscht und der Scan beginnt von vorne.
+// Lesefehler werden zurück gegeben, damit ein Checkpoint nicht wegen eines vorübergehenden Fehlers verloren geht.
+// Ist cfg nil, dann passiert nichts (alle Methoden funktionieren auch mit einem nil checkpointer).
+func newCheckpointer(cfg *CheckpointConfig, rootpath string, debug bool) (*checkpointer, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	root, err := filepath.Abs(rootpath)
+	if err != nil {
+		return nil, err
+	}
+	c := &checkpointer{cfg: cfg, cp: Checkpoint{Root: root, Files: SfDb{}}, last: time.Now()}
+	old, err := ReadCheckpoint(cfg.Path, cfg.Key)
+	if IsCheckpointInvalid(err) {
+		// z.B. abgeschnitten oder mit einem alten Schlüssel: ohne Checkpoint neu anfangen, statt jeden Scan abzubrechen
+		scanDebug(debug, "checkpoint: ignore "+err.Error())
+		os.Remove(cfg.Path)
+		old, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if old != nil && old.Root == root {
+		c.resume = old.Files
+	}
+	return c, nil
+}
+
+// reuse gibt den Eintrag aus dem vorherigen Checkpoint zurück, wenn die Datei seitdem nicht verändert wurde.
+func (c *checkpointer) reuse(relPath string, size uint64, mtime uint64, mtimeNsec uint32, cfg ScanConfig) (SfFile, bool) {
+	if c == nil {
+		return SfFile{}, false
+	}
+	e, ok := c.resume[relPath]
+	if !ok || !e.IsFile || e.Size != size || e.Mtime != mtime || e.MtimeNsec != mtimeNsec || !cfg.matches(e) {
+		return SfFile{}, false
+	}
+	c.add(relPath, e)
+	return e, true
+}
+
+// add merkt sich eine gehashte Datei und schreibt den Checkpoint, wenn das Interval abgelaufen ist.
+func (c *checkpointer) add(relPath string, e SfFile) {
+	if c == nil {
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.cp.Files[relPath] = e
+	c.cp.Bytes += e.Size
+	if time.Since(c.last) >= c.cfg.Interval {
+		c.writeLocked()
+	}
+}
+
+// flush schreibt den Checkpoint (z.B. wenn der Scan abgebrochen wurde).
+func (c *checkpointer) flush() error {
+	if c == nil {
+		return nil
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.writeLocked()
+	return c.err
+}
+
+func (c *checkpointer) writeLocked() {
+	c.cp.Time = time.Now()
+	if err := WriteCheckpoint(c.cfg.Path, c.cfg.Key, c.cp); err != nil && c.err == nil {
+		c.err = err
+	}
+	c.last = time.Now()
+}
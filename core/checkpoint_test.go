@@ -0,0 +1,101 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckpointFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "checkpoint.test")
+	defer os.RemoveAll(dir)
+	path := CheckpointPath(filepath.Join(dir, "index.db"))
+	key := make([]byte, 32)
+
+	if cp, err := ReadCheckpoint(path, key); cp != nil || err != nil {
+		t.Fatalf("missing checkpoint: %v, %v", cp, err)
+	}
+	in := Checkpoint{Root: "/root", Files: SfDb{"a": SfFile{Size: 5, IsFile: true}}, Bytes: 5, Time: time.Unix(100, 0)}
+	if err := WriteCheckpoint(path, key, in); err != nil {
+		t.Fatal(err)
+	}
+	out, err := ReadCheckpoint(path, key)
+	if err != nil || out.Root != "/root" || out.Bytes != 5 || out.Files["a"].Size != 5 || !out.Time.Equal(in.Time) {
+		t.Fatalf("wrong checkpoint: %v, %v", out, err)
+	}
+	key[0] = 1
+	if _, err := ReadCheckpoint(path, key); err == nil {
+		t.Error("wrong key should fail")
+	}
+}
+
+func TestScanFolderResume(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "checkpoint.test")
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "root")
+	os.Mkdir(root, 0700)
+	ioutil.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0600)
+	ioutil.WriteFile(filepath.Join(root, "b.txt"), []byte("b"), 0600)
+	z := unreadableFile(t, filepath.Join(root, "z.txt"))
+
+	cfg := DefaultScanConfig()
+	cfg.Checkpoint = &CheckpointConfig{Path: CheckpointPath(filepath.Join(dir, "index.db")), Key: make([]byte, 32), Interval: time.Hour}
+
+	// der Scan bricht bei z.txt ab, a.txt und b.txt sind im Checkpoint
+	if _, _, _, err := ScanFolderWithConfig(root, SfDb{}, cfg, false); err == nil {
+		t.Fatal("scan should fail")
+	}
+	cp, err := ReadCheckpoint(cfg.Checkpoint.Path, cfg.Checkpoint.Key)
+	if err != nil || cp == nil || len(cp.Files) != 2 || cp.Bytes != 2 {
+		t.Fatalf("wrong checkpoint: %v, %v", cp, err)
+	}
+
+	// ein geänderter Eintrag im Checkpoint beweist, dass nicht neu gehasht wird
+	fake := cp.Files["a.txt"]
+	fake.FileChunks = []ChunkHash{{42}}
+	cp.Files["a.txt"] = fake
+	WriteCheckpoint(cfg.Checkpoint.Path, cfg.Checkpoint.Key, *cp)
+
+	z.Close()
+	os.Remove(filepath.Join(root, "z.txt"))
+	ioutil.WriteFile(filepath.Join(root, "b.txt"), []byte("bb"), 0600) // geändert: neu hashen
+	db, _, summary, err := ScanFolderWithConfig(root, SfDb{}, cfg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db["a.txt"].FileChunks[0][0] != 42 || !strings.Contains(summary, "resumed=1") {
+		t.Errorf("a.txt should be resumed: %s", summary)
+	}
+	if db["b.txt"].Size != 2 {
+		t.Error("b.txt should be scanned again")
+	}
+
+	// ein Checkpoint von einem anderen Ordner wird nicht verwendet
+	cp.Root = "/other"
+	WriteCheckpoint(cfg.Checkpoint.Path, cfg.Checkpoint.Key, *cp)
+	if db, _, _, _ := ScanFolderWithConfig(root, SfDb{}, cfg, false); db["a.txt"].FileChunks[0][0] == 42 {
+		t.Error("checkpoint of another root should be ignored")
+	}
+
+	// ein Checkpoint, der nicht gelesen werden kann, bricht den Scan nicht ab
+	ioutil.WriteFile(cfg.Checkpoint.Path, []byte("kaputt"), 0600)
+	if _, _, _, err := ScanFolderWithConfig(root, SfDb{}, cfg, false); err != nil {
+		t.Errorf("broken checkpoint: %v", err)
+	}
+	if _, err := os.Stat(cfg.Checkpoint.Path); err == nil {
+		t.Error("broken checkpoint should be removed")
+	}
+
+	// ein Lesefehler bricht den Scan ab, der Checkpoint bleibt erhalten
+	os.Mkdir(cfg.Checkpoint.Path, 0700)
+	if _, _, _, err := ScanFolderWithConfig(root, SfDb{}, cfg, false); err == nil {
+		t.Error("read error should stop the scan")
+	}
+	if _, err := os.Stat(cfg.Checkpoint.Path); err != nil {
+		t.Error("checkpoint should not be removed on read errors")
+	}
+	os.Remove(cfg.Checkpoint.Path)
+}
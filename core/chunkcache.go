@@ -0,0 +1,355 @@
+package core
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Der CACHEBLOCKSIZE ist die Größe der Teile, in denen ein Chunk im Cache abgelegt wird.
+// Er sollte ein vielfaches des FUSE-Puffers (131072) sein. Der letzte Block eines Chunks ist meistens kleiner.
+const CACHEBLOCKSIZE = 131072 * 32 // 4194304 Byte (4 Mebibyte)
+
+// CacheChunkStore legt alle gelesenen (verschlüsselten) Daten eines anderen ChunkStores blockweise
+// auf der lokalen Festplatte ab. Ist der Cache größer als maxSize, dann werden die am längsten
+// nicht mehr benutzten Blöcke gelöscht (LRU). Die mtime der Block-Dateien speichert den letzten Zugriff,
+// dadurch bleibt die Reihenfolge auch nach einem Neustart erhalten.
+type CacheChunkStore struct {
+	backend ChunkStore
+	dir     string
+	maxSize int64
+
+	mux     sync.Mutex
+	lru     *list.List               // vorne: zuletzt benutzt
+	entries map[string]*list.Element // Dateiname -> Element in lru
+	size    int64                    // Summe aller Blöcke in bytes
+}
+
+// ein Block im Cache
+type cacheEntry struct {
+	file      string    // Dateiname relativ zum Cache-Ordner
+	size      int64     // Größe in bytes
+	lastTouch time.Time // wann wurde die mtime zuletzt gesetzt
+}
+
+// NewCacheChunkStore erzeugt den Cache vor dem backend. Bereits vorhandene Blöcke im Cache-Ordner
+// werden übernommen.
+func NewCacheChunkStore(backend ChunkStore, dir string, maxSize int64) (*CacheChunkStore, error) {
+	if maxSize < CACHEBLOCKSIZE {
+		return nil, fmt.Errorf("cache size must be at least %d bytes", CACHEBLOCKSIZE)
+	}
+
+	c := &CacheChunkStore{
+		backend: backend,
+		dir:     dir,
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+
+	// Unterordner anlegen
+	for i := 0; i < 256; i++ {
+		if err := os.MkdirAll(filepath.Join(dir, fmt.Sprintf("%02x", i)), 0700); err != nil {
+			return nil, err
+		}
+	}
+
+	// vorhandene Blöcke einlesen
+	var found []*cacheEntry
+	for i := 0; i < 256; i++ {
+		sub := fmt.Sprintf("%02x", i)
+		infos, err := ioutil.ReadDir(filepath.Join(dir, sub))
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			file := filepath.Join(sub, info.Name())
+			if strings.HasPrefix(info.Name(), ".") {
+				// Reste von abgebrochenen Schreibvorgängen
+				os.Remove(filepath.Join(dir, file))
+				continue
+			}
+			found = append(found, &cacheEntry{file: file, size: info.Size(), lastTouch: info.ModTime()})
+		}
+	}
+
+	// älteste zuerst, damit die zuletzt benutzten Blöcke vorne stehen
+	sort.Slice(found, func(a, b int) bool {
+		return found[a].lastTouch.Before(found[b].lastTouch)
+	})
+	for _, e := range found {
+		c.entries[e.file] = c.lru.PushFront(e)
+		c.size += e.size
+	}
+
+	// eventuell wurde maxSize verkleinert
+	c.mux.Lock()
+	c.evict()
+	c.mux.Unlock()
+
+	return c, nil
+}
+
+// Dateiname eines Blocks relativ zum Cache-Ordner: xx/<hex>.<blockNr>
+func cacheBlockFile(chunkName []byte, blockNr int64) string {
+	chunkNameHex := fmt.Sprintf("%x", chunkName)
+	return filepath.Join(chunkNameHex[:2], chunkNameHex+"."+strconv.FormatInt(blockNr, 10))
+}
+
+// OpenChunk gibt einen Handler zurück, der über ReadChunk (also über den Cache) liest.
+func (c *CacheChunkStore) OpenChunk(chunkName []byte) (ChunkHandle, error) {
+	return NewRangeChunkHandle(c, chunkName), nil
+}
+
+// ReadChunk liest die Daten Block für Block aus dem Cache.
+// Fehlende Blöcke werden vollständig vom backend geholt und im Cache abgelegt.
+func (c *CacheChunkStore) ReadChunk(chunkName []byte, offset int64, buf []byte) (int, error) {
+	n := 0
+	for n < len(buf) {
+		blockNr := (offset + int64(n)) / CACHEBLOCKSIZE
+		blockOffset := (offset + int64(n)) % CACHEBLOCKSIZE
+
+		m, blockSize, err := c.readBlock(chunkName, blockNr, blockOffset, buf[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+		// ein nicht voller Block ist der letzte Block des Chunks
+		if blockSize < CACHEBLOCKSIZE && n < len(buf) {
+			return n, io.EOF
+		}
+	}
+	return n, nil
+}
+
+// readBlock liest aus einem einzigen Block und gibt auch die Größe des Blocks zurück.
+func (c *CacheChunkStore) readBlock(chunkName []byte, blockNr int64, blockOffset int64, buf []byte) (int, int64, error) {
+	file := cacheBlockFile(chunkName, blockNr)
+
+	// Cache Treffer?
+	if size, ok := c.touch(file); ok {
+		fh, err := os.Open(filepath.Join(c.dir, file))
+		if err == nil {
+			defer fh.Close()
+			n, err := readFromBlock(fh, size, blockOffset, buf)
+			return n, size, err
+		}
+		// Datei ist weg: aus dem Index löschen und neu holen
+		c.remove(file)
+	}
+
+	// Block vom backend holen
+	block := make([]byte, CACHEBLOCKSIZE)
+	m, err := c.backend.ReadChunk(chunkName, blockNr*CACHEBLOCKSIZE, block)
+	if err != nil && err != io.EOF {
+		return 0, 0, err
+	}
+	block = block[:m]
+
+	// nur vollständige Blöcke im Cache ablegen (Fehler beim Schreiben sind nicht schlimm)
+	// ein kurzer Block muss genau bis zum Ende des Chunks reichen, sonst war es ein abgebrochener Lesevorgang
+	if m > 0 && (m == CACHEBLOCKSIZE || c.lastBlock(chunkName, blockNr, int64(m))) {
+		c.store(file, block)
+	}
+
+	n, err := readFromBlock(bytes.NewReader(block), int64(len(block)), blockOffset, buf)
+	return n, int64(len(block)), err
+}
+
+// lastBlock prüft, ob ein Block mit size bytes der vollständige letzte Block des Chunks ist.
+func (c *CacheChunkStore) lastBlock(chunkName []byte, blockNr int64, size int64) bool {
+	info, err := c.backend.StatChunk(chunkName)
+	return err == nil && info.Size-blockNr*CACHEBLOCKSIZE == size
+}
+
+// readFromBlock kopiert ab blockOffset so viel wie möglich nach buf.
+func readFromBlock(r io.ReaderAt, size int64, blockOffset int64, buf []byte) (int, error) {
+	if blockOffset >= size {
+		return 0, io.EOF
+	}
+	want := size - blockOffset
+	if want > int64(len(buf)) {
+		want = int64(len(buf))
+	}
+	n, err := r.ReadAt(buf[:want], blockOffset)
+	if err == io.EOF && int64(n) == want {
+		err = nil
+	}
+	return n, err
+}
+
+// touch markiert einen Block als zuletzt benutzt und gibt seine Größe zurück.
+func (c *CacheChunkStore) touch(file string) (int64, bool) {
+	c.mux.Lock()
+	el, ok := c.entries[file]
+	if !ok {
+		c.mux.Unlock()
+		return 0, false
+	}
+	c.lru.MoveToFront(el)
+
+	// die mtime nicht bei jedem Zugriff setzen (teuer), eine Minute Genauigkeit reicht
+	e := el.Value.(*cacheEntry)
+	size := e.size
+	now := time.Now()
+	setMtime := now.Sub(e.lastTouch) > time.Minute
+	if setMtime {
+		e.lastTouch = now
+	}
+	c.mux.Unlock()
+
+	// außerhalb des Locks, damit langsame Platten nicht alle Zugriffe blockieren
+	if setMtime {
+		os.Chtimes(filepath.Join(c.dir, file), now, now)
+	}
+	return size, true
+}
+
+// store schreibt einen Block in den Cache und löscht gegebenenfalls alte Blöcke.
+func (c *CacheChunkStore) store(file string, block []byte) {
+	path := filepath.Join(c.dir, file)
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(block)
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	// parallel geholt? dann den alten Eintrag ersetzen
+	if el, ok := c.entries[file]; ok {
+		c.size -= el.Value.(*cacheEntry).size
+		c.lru.Remove(el)
+	}
+	e := &cacheEntry{file: file, size: int64(len(block)), lastTouch: time.Now()}
+	c.entries[file] = c.lru.PushFront(e)
+	c.size += e.size
+	c.evict()
+}
+
+// remove löscht einen Block aus dem Index.
+func (c *CacheChunkStore) remove(file string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if el, ok := c.entries[file]; ok {
+		c.size -= el.Value.(*cacheEntry).size
+		c.lru.Remove(el)
+		delete(c.entries, file)
+	}
+}
+
+// DropChunk löscht alle Blöcke eines Chunks aus dem Cache (z.B. wenn der Hash nicht stimmt).
+// Beim nächsten Zugriff werden die Daten neu vom backend geholt.
+func (c *CacheChunkStore) DropChunk(chunkName []byte) {
+	prefix := cacheBlockFile(chunkName, 0)
+	prefix = prefix[:len(prefix)-1] // ohne die Blocknummer 0
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for file, el := range c.entries {
+		if !strings.HasPrefix(file, prefix) {
+			continue
+		}
+		os.Remove(filepath.Join(c.dir, file))
+		c.size -= el.Value.(*cacheEntry).size
+		c.lru.Remove(el)
+		delete(c.entries, file)
+	}
+}
+
+// evict löscht die ältesten Blöcke, bis der Cache wieder klein genug ist.
+// ACHTUNG: c.mux muss gesperrt sein!
+func (c *CacheChunkStore) evict() {
+	for c.size > c.maxSize && c.lru.Len() > 1 {
+		el := c.lru.Back()
+		e := el.Value.(*cacheEntry)
+		os.Remove(filepath.Join(c.dir, e.file))
+		c.size -= e.size
+		c.lru.Remove(el)
+		delete(c.entries, e.file)
+	}
+}
+
+// Size gibt die aktuelle Größe des Caches in bytes zurück.
+func (c *CacheChunkStore) Size() int64 {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.size
+}
+
+// StatChunk wird direkt an das backend weitergegeben.
+func (c *CacheChunkStore) StatChunk(chunkName []byte) (ChunkInfo, error) {
+	return c.backend.StatChunk(chunkName)
+}
+
+// ListChunks wird direkt an das backend weitergegeben.
+func (c *CacheChunkStore) ListChunks() ([]ChunkInfo, error) {
+	return c.backend.ListChunks()
+}
+
+// RangeChunkHandle ist ein ChunkHandle für Stores, die nur ReadChunk (ranged read) gut können.
+// Jeder Read wird in ein ReadChunk an der aktuellen Position umgewandelt.
+type RangeChunkHandle struct {
+	store     ChunkStore
+	chunkName []byte
+	pos       int64
+}
+
+// NewRangeChunkHandle erzeugt einen RangeChunkHandle, der auf Position 0 steht.
+func NewRangeChunkHandle(store ChunkStore, chunkName []byte) *RangeChunkHandle {
+	return &RangeChunkHandle{store: store, chunkName: chunkName}
+}
+
+// Read liest ab der aktuellen Position.
+func (h *RangeChunkHandle) Read(p []byte) (int, error) {
+	n, err := h.store.ReadChunk(h.chunkName, h.pos, p)
+	h.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek setzt die aktuelle Position. io.SeekEnd wird nicht unterstützt.
+func (h *RangeChunkHandle) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		h.pos = offset
+	case io.SeekCurrent:
+		h.pos += offset
+	default:
+		return h.pos, errors.New("seek from end is not supported")
+	}
+	return h.pos, nil
+}
+
+// Close macht nichts, es gibt keine offenen Dateien.
+func (h *RangeChunkHandle) Close() error {
+	return nil
+}
+
+// Name gibt den Chunknamen als HEX-String zurück.
+func (h *RangeChunkHandle) Name() string {
+	return fmt.Sprintf("%x", h.chunkName)
+}
@@ -0,0 +1,151 @@
+package core
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// countingStore zählt die Zugriffe auf das backend
+type countingStore struct {
+	ChunkStore
+	reads int
+}
+
+func (s *countingStore) ReadChunk(chunkName []byte, offset int64, buf []byte) (int, error) {
+	s.reads++
+	return s.ChunkStore.ReadChunk(chunkName, offset, buf)
+}
+
+func TestCacheChunkStore(t *testing.T) {
+	// backend mit einem Chunk (2.5 Blöcke groß)
+	dir := newTestChunkDir(t)
+	defer os.RemoveAll(dir)
+	dirStore, _ := NewDirChunkStore(dir)
+	name := bytes.Repeat([]byte{0x47}, 64)
+	data := make([]byte, CACHEBLOCKSIZE*5/2)
+	rand.Read(data)
+	ioutil.WriteFile(dirStore.ChunkPath(name), data, 0600)
+	backend := &countingStore{ChunkStore: dirStore}
+
+	cachedir, _ := ioutil.TempDir("", "chunkcache.test")
+	defer os.RemoveAll(cachedir)
+	c, err := NewCacheChunkStore(backend, cachedir, 10*CACHEBLOCKSIZE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// über eine Blockgrenze lesen: zwei Blöcke vom backend
+	buf := make([]byte, 1000)
+	off := int64(CACHEBLOCKSIZE - 500)
+	if n, err := c.ReadChunk(name, off, buf); n != 1000 || err != nil || !bytes.Equal(buf, data[off:off+1000]) {
+		t.Errorf("read #1: n=%d, err=%v", n, err)
+	}
+	if backend.reads != 2 {
+		t.Errorf("backend reads #1: %d", backend.reads)
+	}
+
+	// nochmal lesen: alles aus dem Cache
+	if n, err := c.ReadChunk(name, off+10, buf); n != 1000 || err != nil || !bytes.Equal(buf, data[off+10:off+1010]) {
+		t.Errorf("read #2: n=%d, err=%v", n, err)
+	}
+	if backend.reads != 2 {
+		t.Errorf("backend reads #2: %d", backend.reads)
+	}
+
+	// den ganzen Chunk über den Handler lesen
+	fh, _ := c.OpenChunk(name)
+	all, err := ioutil.ReadAll(fh)
+	if err != nil || !bytes.Equal(all, data) {
+		t.Errorf("read all: len=%d, err=%v", len(all), err)
+	}
+	if backend.reads != 3 || c.Size() != int64(len(data)) {
+		t.Errorf("backend reads #3: %d, cache size %d", backend.reads, c.Size())
+	}
+
+	// über das Ende lesen
+	if n, err := c.ReadChunk(name, int64(len(data))-10, buf); n != 10 || err != io.EOF {
+		t.Errorf("read end: n=%d, err=%v", n, err)
+	}
+
+	// Neustart: der Cache bleibt erhalten
+	backend.reads = 0
+	c, err = NewCacheChunkStore(backend, cachedir, 10*CACHEBLOCKSIZE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh, _ = c.OpenChunk(name)
+	all, _ = ioutil.ReadAll(fh)
+	if !bytes.Equal(all, data) || backend.reads != 0 {
+		t.Errorf("read after restart: reads=%d", backend.reads)
+	}
+
+	// Neustart mit kleinerem Cache: es wird aufgeräumt
+	c, err = NewCacheChunkStore(backend, cachedir, 2*CACHEBLOCKSIZE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Size() > 2*CACHEBLOCKSIZE || c.Size() == 0 {
+		t.Errorf("cache size after restart: %d", c.Size())
+	}
+	fh, _ = c.OpenChunk(name)
+	all, _ = ioutil.ReadAll(fh)
+	if !bytes.Equal(all, data) || c.Size() > 2*CACHEBLOCKSIZE {
+		t.Errorf("read with small cache: size=%d", c.Size())
+	}
+}
+
+// shortStore liefert höchstens max bytes je Zugriff (wie ein abgebrochener Download)
+type shortStore struct {
+	ChunkStore
+	max int
+}
+
+func (s *shortStore) ReadChunk(chunkName []byte, offset int64, buf []byte) (int, error) {
+	if len(buf) > s.max {
+		buf = buf[:s.max]
+	}
+	n, _ := s.ChunkStore.ReadChunk(chunkName, offset, buf)
+	return n, io.EOF
+}
+
+func TestCacheChunkStoreIncomplete(t *testing.T) {
+	dir := newTestChunkDir(t)
+	defer os.RemoveAll(dir)
+	dirStore, _ := NewDirChunkStore(dir)
+	name := bytes.Repeat([]byte{0x48}, 64)
+	data := make([]byte, CACHEBLOCKSIZE+5000)
+	rand.Read(data)
+	ioutil.WriteFile(dirStore.ChunkPath(name), data, 0600)
+
+	cachedir, _ := ioutil.TempDir("", "chunkcache.test")
+	defer os.RemoveAll(cachedir)
+	backend := &shortStore{ChunkStore: dirStore, max: 1000}
+	c, _ := NewCacheChunkStore(backend, cachedir, 10*CACHEBLOCKSIZE)
+
+	// kurze Blöcke werden nicht gespeichert (der letzte Block ist aber auch kurz)
+	buf := make([]byte, 2000)
+	if n, _ := c.ReadChunk(name, 0, buf); n != 1000 || c.Size() != 0 {
+		t.Errorf("short read: n=%d, cache size %d", n, c.Size())
+	}
+	backend.max = 1000000000
+	if n, _ := c.ReadChunk(name, CACHEBLOCKSIZE, buf); n != 2000 || c.Size() != 5000 {
+		t.Errorf("last block: n=%d, cache size %d", n, c.Size())
+	}
+	if n, _ := c.ReadChunk(name, 0, buf); n != 2000 || c.Size() != int64(len(data)) {
+		t.Errorf("full block: n=%d, cache size %d", n, c.Size())
+	}
+
+	// alle Blöcke des Chunks löschen
+	c.DropChunk(name)
+	if c.Size() != 0 {
+		t.Errorf("cache size after drop: %d", c.Size())
+	}
+	if files, _ := ioutil.ReadDir(filepath.Join(cachedir, "48")); len(files) != 0 {
+		t.Errorf("block files after drop: %d", len(files))
+	}
+}
@@ -0,0 +1,195 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ChunkStore abstrahiert den Speicherort der verschlüsselten Chunks.
+// Ein Chunk wird immer über seinen verschlüsselten Namen (CalcChunkCryptHash, 64 bytes) angesprochen.
+// Die erste Implementierung ist DirChunkStore (Ordner mit den Unterordnern 00 bis ff).
+type ChunkStore interface {
+	// OpenChunk öffnet einen Chunk zum sequentiellen Lesen. Der Handler steht auf Position 0.
+	OpenChunk(chunkName []byte) (ChunkHandle, error)
+	// ReadChunk liest ab offset bis buf voll ist oder der Chunk zu Ende ist (wie ReadAt).
+	ReadChunk(chunkName []byte, offset int64, buf []byte) (int, error)
+	// StatChunk gibt Größe und mtime eines Chunks zurück.
+	StatChunk(chunkName []byte) (ChunkInfo, error)
+	// ListChunks listet alle Chunks im Store auf.
+	ListChunks() ([]ChunkInfo, error)
+}
+
+// WritableChunkStore ist ein ChunkStore, in den auch Chunks geschrieben werden können.
+type WritableChunkStore interface {
+	ChunkStore
+	// WriteChunk liest r bis EOF und legt die Daten als Chunk ab.
+	// Gibt r einen Fehler zurück, dann darf kein (halber) Chunk im Store zurück bleiben.
+	WriteChunk(chunkName []byte, r io.Reader) error
+	// RemoveChunk löscht einen Chunk.
+	RemoveChunk(chunkName []byte) error
+}
+
+// ChunkHandle ist ein geöffneter Chunk. *os.File erfüllt dieses Interface.
+type ChunkHandle interface {
+	io.Reader
+	io.Seeker
+	io.Closer
+	Name() string
+}
+
+// ChunkInfo beschreibt einen Chunk im ChunkStore.
+type ChunkInfo struct {
+	Name  ChunkHash // der verschlüsselte Name (CalcChunkCryptHash)
+	Size  int64     // Größe in bytes
+	Mtime time.Time // letzte Änderung
+}
+
+// DirChunkStore ist ein ChunkStore auf einem lokalen (oder per FUSE gemounteten) Ordner.
+// Die Chunks liegen als Datei unter root/xx/<hex>, wobei xx die ersten beiden Zeichen des Namens sind.
+type DirChunkStore struct {
+	root string
+}
+
+// NewDirChunkStore prüft das Layout des Ordners und gibt einen DirChunkStore zurück.
+// Es müssen die Unterordner 00 bis ff vorhanden sein, sonst wird ein Fehler zurück gegeben.
+func NewDirChunkStore(root string) (*DirChunkStore, error) {
+	s := &DirChunkStore{root: root}
+	if err := s.checkLayout(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// CreateDirChunkStore legt die Unterordner 00 bis ff an (falls nötig) und gibt einen DirChunkStore zurück.
+func CreateDirChunkStore(root string) (*DirChunkStore, error) {
+	for i := 0; i < 256; i++ {
+		if err := os.MkdirAll(filepath.Join(root, fmt.Sprintf("%02x", i)), 0700); err != nil {
+			return nil, err
+		}
+	}
+	return NewDirChunkStore(root)
+}
+
+// WaitDirChunkStore wartet maximal timeout, bis der Ordner das richtige Layout hat.
+// Das ist z.B. bei einem gerade erst gestarteten rclone mount notwendig.
+func WaitDirChunkStore(root string, timeout time.Duration) (*DirChunkStore, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		s, err := NewDirChunkStore(root)
+		if err == nil || time.Now().After(deadline) {
+			return s, err
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// Stichprobe: Es müssen die ganzen 00 .. ff Ordner vorhanden sein
+func (s *DirChunkStore) checkLayout() error {
+	testfolder := []string{"00", "47", "83", "a0", "de", "ff"}
+	for _, t := range testfolder {
+		if _, e := os.Stat(filepath.Join(s.root, t)); e != nil {
+			// Ordner existiert nicht
+			return errors.New("wrong chunk folder! can't find sub folder " + t)
+		}
+	}
+	return nil
+}
+
+// Root gibt den Pfad zum Ordner zurück.
+func (s *DirChunkStore) Root() string {
+	return s.root
+}
+
+// ChunkPath gibt den Pfad zu einem Chunk zurück: root/xx/<hex>
+func (s *DirChunkStore) ChunkPath(chunkName []byte) string {
+	chunkNameHex := fmt.Sprintf("%x", chunkName)
+	return filepath.Join(s.root, chunkNameHex[:2], chunkNameHex)
+}
+
+// OpenChunk öffnet die Chunk-Datei.
+func (s *DirChunkStore) OpenChunk(chunkName []byte) (ChunkHandle, error) {
+	return os.Open(s.ChunkPath(chunkName))
+}
+
+// ReadChunk öffnet die Chunk-Datei und liest ab offset.
+// Ein io.EOF wird nur zurück gegeben, wenn buf nicht gefüllt werden konnte.
+func (s *DirChunkStore) ReadChunk(chunkName []byte, offset int64, buf []byte) (int, error) {
+	fh, err := os.Open(s.ChunkPath(chunkName))
+	if err != nil {
+		return 0, err
+	}
+	defer fh.Close()
+	return fh.ReadAt(buf, offset)
+}
+
+// StatChunk gibt die Eckdaten der Chunk-Datei zurück.
+func (s *DirChunkStore) StatChunk(chunkName []byte) (ChunkInfo, error) {
+	info, err := os.Stat(s.ChunkPath(chunkName))
+	if err != nil {
+		return ChunkInfo{}, err
+	}
+	ch, err := Sha512ToChunkHash(chunkName)
+	if err != nil {
+		return ChunkInfo{}, err
+	}
+	return ChunkInfo{Name: ch, Size: info.Size(), Mtime: info.ModTime()}, nil
+}
+
+// ListChunks geht alle 256 Unterordner durch und listet alle Chunks auf.
+// Dateien, deren Name kein gültiger Chunkname ist, werden ignoriert.
+func (s *DirChunkStore) ListChunks() ([]ChunkInfo, error) {
+	var ret []ChunkInfo
+	for i := 0; i < 256; i++ {
+		sub := fmt.Sprintf("%02x", i)
+		infos, err := ioutil.ReadDir(filepath.Join(s.root, sub))
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			ch, err := HexToChunkHash(info.Name())
+			if err != nil || info.IsDir() || info.Name()[:2] != sub {
+				continue
+			}
+			ret = append(ret, ChunkInfo{Name: ch, Size: info.Size(), Mtime: info.ModTime()})
+		}
+	}
+	return ret, nil
+}
+
+// WriteChunk schreibt den Chunk zuerst in eine temporäre Datei im selben Ordner
+// und benennt sie erst dann um, wenn alles geschrieben wurde.
+func (s *DirChunkStore) WriteChunk(chunkName []byte, r io.Reader) error {
+	path := s.ChunkPath(chunkName)
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+
+	// Daten schreiben
+	_, err = io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+
+	// im Fehlerfall aufräumen
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// RemoveChunk löscht die Chunk-Datei.
+func (s *DirChunkStore) RemoveChunk(chunkName []byte) error {
+	return os.Remove(s.ChunkPath(chunkName))
+}
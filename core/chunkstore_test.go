@@ -0,0 +1,82 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// legt einen leeren Chunk-Ordner mit den Unterordnern 00 bis ff an
+func newTestChunkDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "chunkstore.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 256; i++ {
+		os.Mkdir(filepath.Join(dir, fmt.Sprintf("%02x", i)), 0700)
+	}
+	return dir
+}
+
+func TestNewDirChunkStoreWrongFolder(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "chunkstore.test")
+	defer os.RemoveAll(dir)
+
+	if _, err := NewDirChunkStore(dir); err == nil {
+		t.Errorf("NewDirChunkStore should fail without sub folders")
+	}
+}
+
+func TestDirChunkStore(t *testing.T) {
+	dir := newTestChunkDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := NewDirChunkStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// einen Chunk ablegen
+	name := bytes.Repeat([]byte{0xab}, 64)
+	data := []byte("ich bin ein verschluesselter chunk")
+	if err := ioutil.WriteFile(s.ChunkPath(name), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// ranged read
+	buf := make([]byte, 5)
+	n, err := s.ReadChunk(name, 4, buf)
+	if err != nil || n != 5 || !bytes.Equal(buf, data[4:9]) {
+		t.Errorf("ReadChunk: n=%d, err=%v, buf=%s", n, err, buf)
+	}
+
+	// open
+	fh, err := s.OpenChunk(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, _ := ioutil.ReadAll(fh)
+	fh.Close()
+	if !bytes.Equal(all, data) {
+		t.Errorf("OpenChunk: %s", all)
+	}
+
+	// stat
+	info, err := s.StatChunk(name)
+	if err != nil || info.Size != int64(len(data)) || !bytes.Equal(info.Name[:], name) {
+		t.Errorf("StatChunk: %v, %v", info, err)
+	}
+	if _, err := s.StatChunk(bytes.Repeat([]byte{0x01}, 64)); err == nil {
+		t.Errorf("StatChunk should fail for missing chunk")
+	}
+
+	// list (fremde Dateien werden ignoriert)
+	ioutil.WriteFile(filepath.Join(dir, "ab", "README"), data, 0600)
+	list, err := s.ListChunks()
+	if err != nil || len(list) != 1 || list[0].Name != info.Name {
+		t.Errorf("ListChunks: %v, %v", list, err)
+	}
+}
@@ -12,6 +12,9 @@ import (
 	"io/ioutil"
 	"encoding/hex"
 	"path/filepath"
+	"sort"
+	"fmt"
+	"encoding/binary"
 )
 
 // SfDb ist eine Map, dessen Key der Pfad eines Ordners oder einer Datei ist und
@@ -26,12 +29,68 @@ type SfFile struct {
 	Size  uint64 // size in bytes
 	Mtime uint64 // time of last modification
 
+	// POSIX metadata (db version 3), only valid if HasMeta is set (see FileMode and Owner)
+	HasMeta   bool   // false for entries from older dbs
+	Mode      uint32 // permission bits incl. setuid, setgid and sticky (07777)
+	Uid       uint32 // owner
+	Gid       uint32 // group
+	MtimeNsec uint32 // nanoseconds of Mtime
+	Ctime     uint64 // time of last status change
+	CtimeNsec uint32 // nanoseconds of Ctime
+
 	// file or folder
-	IsFile        bool            // true is file, false is folder
+	IsFile        bool            // true is file, false is folder (or link)
+	IsLink        bool            // symbolic link (IsFile is false), the target is in LinkTarget
+	LinkTarget    string          // if link: target of the link (as returned by readlink)
 	FileChunks    []ChunkHash     // if file: the full chunk list of this file
+	ChunkOffsets  []uint64        // if file: start of each chunk (content-defined chunking), nil for fixed chunk size
+	ChunkSize     uint64          // if file: fixed chunk size (0 is CHUNKSIZE), not used with ChunkOffsets
 	FolderContent []FolderContent // if folder: a list ob sub elements of this folder
 }
 
+// FixedChunkSize gibt die feste Chunkgröße der Datei zurück (ohne ChunkOffsets).
+func (f SfFile) FixedChunkSize() uint64 {
+	if f.ChunkSize == 0 {
+		return CHUNKSIZE
+	}
+	return f.ChunkSize
+}
+
+// ChunkBounds gibt den Start (Position in der Klartext Datei) und die Größe eines Chunks zurück.
+// Ohne ChunkOffsets sind alle Chunks FixedChunkSize groß (außer dem letzten).
+// Gibt es den Chunk nicht, dann ist die Größe 0.
+func (f SfFile) ChunkBounds(chunkNr int) (offset uint64, size uint64) {
+	if chunkNr < 0 || chunkNr >= len(f.FileChunks) {
+		return f.Size, 0
+	}
+	if f.ChunkOffsets == nil {
+		chunkSize := f.FixedChunkSize()
+		return uint64(chunkNr) * chunkSize, calcFixedChunkSize(chunkNr, f.Size, chunkSize)
+	}
+	offset = f.ChunkOffsets[chunkNr]
+	end := f.Size
+	if chunkNr+1 < len(f.ChunkOffsets) {
+		end = f.ChunkOffsets[chunkNr+1]
+	}
+	return offset, end - offset
+}
+
+// ChunkAt gibt den Chunk zurück, in dem das byte an offset liegt, und die Position in diesem Chunk.
+// Liegt offset hinter dem Dateiende, dann ist chunkNr die Anzahl der Chunks.
+func (f SfFile) ChunkAt(offset uint64) (chunkNr int, chunkOffset uint64) {
+	if offset >= f.Size {
+		return len(f.FileChunks), 0
+	}
+	if f.ChunkOffsets == nil {
+		chunkSize := f.FixedChunkSize()
+		return int(offset / chunkSize), offset % chunkSize
+	}
+	chunkNr = sort.Search(len(f.ChunkOffsets), func(i int) bool {
+		return f.ChunkOffsets[i] > offset
+	}) - 1
+	return chunkNr, offset - f.ChunkOffsets[chunkNr]
+}
+
 // ReverseSfDb bietet die Möglichkeit, zu einem verschlüsselten ChunkHash (das ist der Dateiname eines Chunks
 // im ChunkStorage), den Pfad zur klartext Datei zu erfragen.
 type ReverseSfDb map[ChunkHash]PathAndIndex
@@ -41,6 +100,7 @@ type ReverseSfDb map[ChunkHash]PathAndIndex
 type PathAndIndex struct {
 	Path      string
 	Index     int
+	Offset    uint64 // Start des Chunks in der Klartext Datei
 	ChunkKey  []byte
 	ChunkSize uint64
 }
@@ -50,20 +110,112 @@ type PathAndIndex struct {
 type ChunkHash [64]byte
 
 // FolderContent speichert den Namen eines Unter-Elements eines Ordners und
-// ob es sich um eine Dateioder einen Ordner handelt.
+// ob es sich um eine Datei, einen Link oder einen Ordner handelt.
 type FolderContent struct {
 	Name   string
 	IsFile bool
+	IsLink bool
+}
+
+// Kopf einer DB Datei (ab Version 1). Der Kopf wird bei der Verschlüsselung als additional data
+// mit authentisiert, er kann also nicht unbemerkt verändert werden.
+//   magic       4 bytes  "SFDB"
+//   version     1 byte
+//   keyKdf      1 byte   Ableitung der Schlüssel aus dem Keyfile
+//   chunkCipher 1 byte   Verschlüsselung der Chunks
+//   reserved    1 byte
+//   chunkSize   8 bytes  (big endian) feste Chunkgröße für neue Dateien (scan --chunksize)
+// Danach folgen nonce (12 bytes) und ciphertext. Alte DB Dateien (Version 0) bestehen nur aus nonce und ciphertext.
+const (
+	dbMagic      = "SFDB"
+	dbHeaderSize = 16
+	DbVersion    = 4 // aktuelle Version des DB Formats (2: SfFile.ChunkOffsets, 3: POSIX Metadaten, 4: Links)
+
+	KdfPbkdf2Sha512 = 1 // cryptSecret, hashSecret und indexSecret mit PBKDF2-SHA512 (LoadKeyfile)
+	CipherAesCtr    = 1 // AES-256-CTR mit einem Schlüssel pro Chunk (CalcChunkKey, CryptBytes)
+)
+
+// DbHeader beschreibt das Format einer DB Datei und der zugehörigen Chunks.
+type DbHeader struct {
+	Version     uint8
+	KeyKdf      uint8
+	ChunkCipher uint8
+	ChunkSize   uint64
+}
+
+// NewDbHeader gibt den Kopf für neue DB Dateien zurück.
+func NewDbHeader() DbHeader {
+	return DbHeader{
+		Version:     DbVersion,
+		KeyKdf:      KdfPbkdf2Sha512,
+		ChunkCipher: CipherAesCtr,
+		ChunkSize:   CHUNKSIZE,
+	}
+}
+
+// legacyDbHeader beschreibt alte DB Dateien ohne Kopf.
+func legacyDbHeader() DbHeader {
+	h := NewDbHeader()
+	h.Version = 0
+	return h
+}
+
+// bytes gibt den Kopf so zurück, wie er in der Datei steht.
+func (h DbHeader) bytes() []byte {
+	b := make([]byte, dbHeaderSize)
+	copy(b, dbMagic)
+	b[4] = h.Version
+	b[5] = h.KeyKdf
+	b[6] = h.ChunkCipher
+	binary.BigEndian.PutUint64(b[8:], h.ChunkSize)
+	return b
+}
+
+// parseDbHeader liest den Kopf einer DB Datei.
+func parseDbHeader(b []byte) DbHeader {
+	return DbHeader{
+		Version:     b[4],
+		KeyKdf:      b[5],
+		ChunkCipher: b[6],
+		ChunkSize:   binary.BigEndian.Uint64(b[8:16]),
+	}
+}
+
+// check prüft, ob dieses Programm mit dem Format umgehen kann.
+func (h DbHeader) check() error {
+	if h.Version > DbVersion {
+		return fmt.Errorf("db version %d is not supported (max. %d), please update splitfuse", h.Version, DbVersion)
+	}
+	if h.KeyKdf != KdfPbkdf2Sha512 {
+		return fmt.Errorf("db uses unknown key derivation %d", h.KeyKdf)
+	}
+	if h.ChunkCipher != CipherAesCtr {
+		return fmt.Errorf("db uses unknown chunk cipher %d", h.ChunkCipher)
+	}
+	if err := CheckChunkSize(h.ChunkSize); err != nil {
+		return fmt.Errorf("db uses chunk size %d: %v", h.ChunkSize, err)
+	}
+	return nil
 }
 
 // DbToEncGOB serialized und verschlüsselt das SfDb Objekt und gibt nonce und den ciphertext zurück.
 // Im Fehlerfall wird ein Error zurück gegeben und der ciphertext ist Null.
 func DbToEncGOB(key []byte, db SfDb) (nonce []byte, ciphertext []byte, err error) {
+	return dbSeal(key, db, nil)
+}
+
+// dbSeal serialisiert und verschlüsselt die DB. additionalData wird mit authentisiert.
+func dbSeal(key []byte, db SfDb, additionalData []byte) (nonce []byte, ciphertext []byte, err error) {
+	return sealGOB(key, db, additionalData)
+}
+
+// sealGOB serialisiert (gob) und verschlüsselt ein beliebiges Objekt. additionalData wird mit authentisiert.
+func sealGOB(key []byte, v interface{}, additionalData []byte) (nonce []byte, ciphertext []byte, err error) {
 
 	// serialisiertes Objekt als bytes (plaintext)
 	var plaintext = bytes.Buffer{}
 	encoder := gob.NewEncoder(&plaintext)
-	err = encoder.Encode(db)
+	err = encoder.Encode(v)
 	if err != nil {
 		return
 	}
@@ -88,7 +240,7 @@ func DbToEncGOB(key []byte, db SfDb) (nonce []byte, ciphertext []byte, err error
 	}
 
 	// encrypts and authenticates plaintext
-	ciphertext = aesgcm.Seal(nil, nonce, plaintext.Bytes(), nil)
+	ciphertext = aesgcm.Seal(nil, nonce, plaintext.Bytes(), additionalData)
 
 	// FIN
 	return
@@ -97,6 +249,17 @@ func DbToEncGOB(key []byte, db SfDb) (nonce []byte, ciphertext []byte, err error
 // DbFromEncGOB entschlüsselt und authentisirt den ciphertext.
 // Im Fehlerfall wird ein error zurück gegeben.
 func DbFromEncGOB(key []byte, nonce []byte, ciphertext []byte) (db SfDb, err error) {
+	return dbOpen(key, nonce, ciphertext, nil)
+}
+
+// dbOpen entschlüsselt und authentisiert den ciphertext und die additionalData.
+func dbOpen(key []byte, nonce []byte, ciphertext []byte, additionalData []byte) (db SfDb, err error) {
+	err = openGOB(key, nonce, ciphertext, additionalData, &db)
+	return
+}
+
+// openGOB entschlüsselt und authentisiert den ciphertext und die additionalData und dekodiert das Ergebnis nach v.
+func openGOB(key []byte, nonce []byte, ciphertext []byte, additionalData []byte, v interface{}) (err error) {
 
 	// create AES cipher with 16, 24, or 32 bytes key
 	block, err := aes.NewCipher(key)
@@ -111,101 +274,177 @@ func DbFromEncGOB(key []byte, nonce []byte, ciphertext []byte) (db SfDb, err err
 	}
 
 	// decrypts and authenticates ciphertext
-	plaintext, err := aesgcm.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := aesgcm.Open(nil, nonce, ciphertext, additionalData)
 	if err != nil {
 		return
 	}
 
-	// decode the plaintext and update db *SfDb
+	// decode the plaintext and update v
 	decoder := gob.NewDecoder(bytes.NewReader(plaintext))
-	err = decoder.Decode(&db)
-	return
+	return decoder.Decode(v)
 }
 
-// DbToFile schreibt die DB in eine Datei.
+// DbToFile schreibt die DB mit dem aktuellen Kopf (NewDbHeader) in eine Datei.
 // ACHTUNG: Das Ziel wird dabei überschrieben!
 // Bei Problemen wird ein Fehler zurück gegeben der behandelt werden muss!
 func DbToFile(path string, key []byte, db SfDb) error {
+	return WriteDbFile(path, key, NewDbHeader(), db, false)
+}
+
+// WriteDbFile schreibt die DB mit dem Kopf hdr in eine Datei.
+// Die Daten werden zuerst in eine temporäre Datei im selben Ordner geschrieben und erst nach einem fsync
+// über das Ziel umbenannt. Ein Absturz hinterlässt also nie eine halbe DB und ein Leser (checkDbUpdate)
+// sieht immer entweder die alte oder die neue DB.
+// Ist backup gesetzt, dann bleibt die vorherige DB als <path>.bak erhalten.
+// Bei Problemen wird ein Fehler zurück gegeben der behandelt werden muss!
+func WriteDbFile(path string, key []byte, hdr DbHeader, db SfDb, backup bool) error {
+	if hdr.Version != DbVersion {
+		return fmt.Errorf("can't write db version %d", hdr.Version)
+	}
+	if err := hdr.check(); err != nil {
+		return err
+	}
+
 	// db verschlüsseln
-	nonce, ciphertext, err := DbToEncGOB(key, db)
+	header := hdr.bytes()
+	nonce, ciphertext, err := dbSeal(key, db, header)
 	if err != nil {
 		return err
 	}
+	return writeFileAtomic(path, backup, header, nonce, ciphertext)
+}
 
-	// Datei überschreiben
-	fh, err := os.Create(path)
-	defer fh.Close()
+// writeFileAtomic schreibt die Teile über eine temporäre Datei (fsync und rename, siehe WriteDbFile) nach path.
+// Ist backup gesetzt, dann bleibt die vorherige Datei als <path>.bak erhalten.
+func writeFileAtomic(path string, backup bool, parts ...[]byte) error {
+	// temporäre Datei im selben Ordner (rename geht nur innerhalb eines Dateisystems)
+	dir := filepath.Dir(path)
+	tmp, err := ioutil.TempFile(dir, ".tmp-"+filepath.Base(path))
 	if err != nil {
 		return err
 	}
+	defer os.Remove(tmp.Name()) // nach dem rename ohne Wirkung
 
-	// nonce schreiben
-	n, err := fh.Write(nonce)
+	// alle Teile (z.B. header, nonce und ciphertext) schreiben
+	for _, b := range parts {
+		if _, err = tmp.Write(b); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
 	if err != nil {
 		return err
 	}
-	if n != len(nonce) {
-		return errors.New("write nonce failed")
+
+	// vorherige DB behalten
+	if backup {
+		if err := backupFile(path, path+".bak"); err != nil {
+			return err
+		}
 	}
 
-	// ciphertext schreiben
-	n, err = fh.Write(ciphertext)
-	if err != nil {
+	// ersetzen und den Ordner syncen, damit auch das rename auf der Platte ist
+	if err := os.Rename(tmp.Name(), path); err != nil {
 		return err
 	}
-	if n != len(ciphertext) {
-		return errors.New("write ciphertext failed")
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
 	}
 
 	// FIN
 	return nil
 }
 
+// backupFile legt eine Kopie von path unter bak ab (als hard link, wenn möglich).
+// Existiert path nicht, dann passiert nichts.
+func backupFile(path string, bak string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	os.Remove(bak)
+	if os.Link(path, bak) == nil {
+		return nil
+	}
+
+	// hard links werden nicht unterstützt: kopieren
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(bak, data, 0600)
+}
+
 // DbFromFile liest eine Datei und gibt ein SfDB Objekt zurück.
 // Im Fehlerfall wird ein Error zurck gegebe, der behandelt werden muss.
 // Ein Beispiel für einen Fähler wäre, das Lesen einer noch nicht fertig geschriebenen DB Datei.
 // Existiert die Datei überhaupt nicht, dann wird eine leere DB zurück gegeben
 func DbFromFile(path string, key []byte) (db SfDb, err error) {
+	db, _, err = ReadDbFile(path, key)
+	return
+}
+
+// ReadDbFile liest eine Datei und gibt die DB und ihren Kopf zurück.
+// Es werden das aktuelle Format mit Kopf und das alte Format (nur nonce und ciphertext) gelesen.
+// Existiert die Datei überhaupt nicht, dann wird eine leere DB mit dem aktuellen Kopf zurück gegeben.
+func ReadDbFile(path string, key []byte) (db SfDb, hdr DbHeader, err error) {
 	gcmStandardNonceSize := 12
 
 	// keine Datei -> leere DB
 	_, err = os.Stat(path)
 	if err != nil {
 		// datei existiert nicht
-		return SfDb{}, nil
-	}
-
-	// Datei öffnen
-	fh, err := os.Open(path)
-	defer fh.Close()
-	if err != nil {
-		return // z.B. error: file not found
+		return SfDb{}, NewDbHeader(), nil
 	}
 
 	// alles lesen
-	filebytes, err := ioutil.ReadAll(fh)
+	filebytes, err := ioutil.ReadFile(path)
 	if err != nil {
 		return // z.B. error: file to large
 	}
 
+	// aktuelles Format mit Kopf
+	if len(filebytes) > dbHeaderSize+gcmStandardNonceSize && bytes.HasPrefix(filebytes, []byte(dbMagic)) {
+		header := filebytes[:dbHeaderSize]
+		hdr = parseDbHeader(header)
+		if err = hdr.check(); err != nil {
+			return
+		}
+		nonce := filebytes[dbHeaderSize : dbHeaderSize+gcmStandardNonceSize]
+		ciphertext := filebytes[dbHeaderSize+gcmStandardNonceSize:]
+		db, err = dbOpen(key, nonce, ciphertext, header)
+		if err == nil {
+			return
+		}
+		// eine alte DB, deren nonce zufällig mit dem magic beginnt? -> unten weiter versuchen
+	}
+
 	// Datei muss groß genug sein
 	if len(filebytes) < gcmStandardNonceSize+1 {
 		err = errors.New("db file is too short")
 		return
 	}
 
-	// daten extrahieren
+	// altes Format: daten extrahieren
 	nonce := filebytes[:gcmStandardNonceSize]
 	ciphertext := filebytes[gcmStandardNonceSize:]
 
 	// encrtypt
-	db, err = DbFromEncGOB(key, nonce, ciphertext)
-	if err != nil {
-		return // z.B. error: Authentication failed
+	db, legacyErr := dbOpen(key, nonce, ciphertext, nil)
+	if legacyErr != nil {
+		if err == nil {
+			err = legacyErr // z.B. error: Authentication failed
+		}
+		return
 	}
 
 	// FIN
-	return
+	return db, legacyDbHeader(), nil
 }
 
 // Wandelt ein Sha512 Hash in ein [64]byte Array um.
@@ -220,20 +459,25 @@ func Sha512ToChunkHash(sha512 []byte) (ChunkHash, error) {
 	return retbytes, nil
 }
 
+// HexToChunkHash wandelt einen Chunknamen als HEX-String (128 Zeichen) in ein [64]byte Array um.
+func HexToChunkHash(name string) (ChunkHash, error) {
+	h, err := hex.DecodeString(name)
+	if err != nil {
+		return ChunkHash{}, err
+	}
+	return Sha512ToChunkHash(h)
+}
+
 // GetPAI erweitert ReverseSfDb und nimmt einen Pfad entgegen und sucht dann das richtige PAI Objekt
 func (rdb *ReverseSfDb) GetPAI(name string) (PathAndIndex, error) {
 
 	// Den ChunkName (entspricht dem verschlüsselten ChunkHash),
 	// der als HEX-String vorliegt, muss in ein [64]byte umgewandelt werden
-	h, err := hex.DecodeString(filepath.Base(name))
+	chunkname, err := HexToChunkHash(filepath.Base(name))
 	if err != nil {
 		// Umwandlung des HexString gescheitert
 		return PathAndIndex{}, err
 	}
-	chunkname, err := Sha512ToChunkHash(h)
-	if err != nil {
-		return PathAndIndex{}, err
-	}
 
 	// Mit dem Chunknamen kann der relative Pfad der klartext Datei ermittelt werden
 	// Die chunkNr beschreibt, welcher Teil der Klartextdatei gelesen werden muss
@@ -249,18 +493,32 @@ func (rdb *ReverseSfDb) GetPAI(name string) (PathAndIndex, error) {
 
 // CalcChunkSize berechnet wie groß die einzelnen Chunks sind, bei einer bestimmten Klartextdateigröße
 func CalcChunkSize(chunkNr int, fileSize uint64) (chunkSize uint64) {
-	test1 := uint64(chunkNr+1) * CHUNKSIZE
+	return calcFixedChunkSize(chunkNr, fileSize, CHUNKSIZE)
+}
+
+// calcFixedChunkSize ist CalcChunkSize für eine beliebige feste Chunkgröße.
+func calcFixedChunkSize(chunkNr int, fileSize uint64, fixedSize uint64) (chunkSize uint64) {
+	test1 := uint64(chunkNr+1) * fixedSize
 	test2 := test1 - fileSize
 
 	if test1 <= fileSize {
-		return CHUNKSIZE
+		return fixedSize
 	}
 
-	if test2 > CHUNKSIZE {
+	if test2 > fixedSize {
 		return 0
 	}
 
-	return fileSize % CHUNKSIZE
+	return fileSize % fixedSize
+}
+
+// CheckChunkSize prüft eine feste Chunkgröße. Sie muss ein vielfaches des FUSE-Puffers (131072 Byte)
+// und damit auch der AES Blockgröße sein.
+func CheckChunkSize(chunkSize uint64) error {
+	if chunkSize == 0 || chunkSize%131072 != 0 || chunkSize%aes.BlockSize != 0 {
+		return fmt.Errorf("chunk size must be a multiple of 131072 bytes (FUSE) and %d bytes (AES)", aes.BlockSize)
+	}
+	return nil
 }
 
 // GetReverseSfDb erweitert SfDb und gibt eine ReverseSfDb der SfDb zurück.
@@ -278,7 +536,7 @@ func (db *SfDb) GetReverseSfDb(k KeyFile) ReverseSfDb {
 		// alle gespeicherten ChunkHashes  (Hash über den Klartext)
 		for i, h := range f.FileChunks {
 			// chunksize (ist er 0 bytes, dann nicht beachten)
-			chunkSize := CalcChunkSize(i, f.Size)
+			offset, chunkSize := f.ChunkBounds(i)
 			if chunkSize < 1 {
 				continue
 			}
@@ -286,9 +544,57 @@ func (db *SfDb) GetReverseSfDb(k KeyFile) ReverseSfDb {
 			// und in ein core.ChunkHash konvertiert werden
 			ch, _ := Sha512ToChunkHash(k.CalcChunkCryptHash(h[:]))
 			// das ergibt dann den crypt Hash, der auch der Dateiname des Chunks ist
-			crypHashIndex[ch] = PathAndIndex{Path: p, Index: i, ChunkKey: k.CalcChunkKey(h[:]), ChunkSize: chunkSize}
+			crypHashIndex[ch] = PathAndIndex{Path: p, Index: i, Offset: offset, ChunkKey: k.CalcChunkKey(h[:]), ChunkSize: chunkSize}
 		}
 	}
 
 	return crypHashIndex
 }
+
+// ein Chunk, der in der DB referenziert wird
+type chunkRef struct {
+	hash   ChunkHash // Hash über den Klartext
+	name   []byte    // verschlüsselter Chunkname
+	size   uint64    // Größe des Chunks
+	index  int       // Position des Chunks in der Datei paths[0]
+	offset uint64    // Start des Chunks in der Datei paths[0]
+	paths  []string  // alle Dateien, die den Chunk enthalten
+}
+
+// collectChunkRefs sammelt alle Chunks aus der DB (gemeinsame Chunks nur einmal) und merkt sich die Pfade.
+// Die Reihenfolge ist durch die sortierten Pfade immer gleich.
+func collectChunkRefs(db SfDb, k KeyFile) []*chunkRef {
+	paths := make([]string, 0, len(db))
+	for p := range db {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var refs []*chunkRef
+	byName := make(map[ChunkHash]*chunkRef)
+	for _, p := range paths {
+		f := db[p]
+		// Ordner und leere Dateien haben keine Chunks
+		if f.Size < 1 || !f.IsFile {
+			continue
+		}
+		for i, h := range f.FileChunks {
+			offset, size := f.ChunkBounds(i)
+			if size < 1 {
+				continue
+			}
+			name := k.CalcChunkCryptHash(h[:])
+			ch, _ := Sha512ToChunkHash(name)
+			r, ok := byName[ch]
+			if !ok {
+				r = &chunkRef{hash: h, name: name, size: size, index: i, offset: offset}
+				byName[ch] = r
+				refs = append(refs, r)
+			}
+			if len(r.paths) == 0 || r.paths[len(r.paths)-1] != p {
+				r.paths = append(r.paths, p)
+			}
+		}
+	}
+	return refs
+}
@@ -9,6 +9,8 @@ import (
 	"encoding/hex"
 	"path/filepath"
 	"os"
+	"io/ioutil"
+	"strings"
 )
 
 var (
@@ -54,13 +56,13 @@ func init() {
 			Mtime:         34,
 			IsFile:        false,
 			FileChunks:    nil,
-			FolderContent: []FolderContent{{"file", true}, {"folder", false}},
+			FolderContent: []FolderContent{{"file", true, false}, {"folder", false, false}},
 		},
 		"großes haus": SfFile{
 			Size:          9,
 			Mtime:         34,
 			IsFile:        true,
-			FolderContent: []FolderContent{{"file", true}, {"folder", false}},
+			FolderContent: []FolderContent{{"file", true, false}, {"folder", false, false}},
 		},
 		"jejejeje": SfFile{
 			Size:   923923,
@@ -239,3 +241,99 @@ func TestCalcChunkSize(t *testing.T) {
 		t.Errorf("TestCalcChunkSize Test #21: (%d)", x)
 	}
 }
+
+// Neue DB Dateien haben einen Kopf, der mit authentisiert ist.
+func TestDbFileHeader(t *testing.T) {
+	if err := DbToFile(writeTestFile, key, db); err != nil {
+		t.Fatal(err)
+	}
+	readdb, hdr, err := ReadDbFile(writeTestFile, key)
+	if err != nil || !reflect.DeepEqual(readdb, db) {
+		t.Errorf("ReadDbFile: %v", err)
+	}
+	if hdr != NewDbHeader() {
+		t.Errorf("wrong header: %+v", hdr)
+	}
+
+	// veränderter Kopf
+	filebytes, _ := ioutil.ReadFile(writeTestFile)
+	filebytes[7] = 1 // reserved
+	ioutil.WriteFile(writeTestFile, filebytes, 0600)
+	if _, err := DbFromFile(writeTestFile, key); err == nil {
+		t.Errorf("DbFromFile with tampered header should fail")
+	}
+
+	// unbekannte Version
+	filebytes[7] = 0
+	filebytes[4] = DbVersion + 1
+	ioutil.WriteFile(writeTestFile, filebytes, 0600)
+	if _, err := DbFromFile(writeTestFile, key); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("DbFromFile with newer version should fail: %v", err)
+	}
+}
+
+// Alte DB Dateien (nur nonce und ciphertext) müssen weiterhin gelesen werden.
+func TestDbFileLegacy(t *testing.T) {
+	nonce, ciphertext, err := DbToEncGOB(key, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(writeTestFile, append(nonce, ciphertext...), 0600)
+
+	readdb, hdr, err := ReadDbFile(writeTestFile, key)
+	if err != nil || !reflect.DeepEqual(readdb, db) {
+		t.Errorf("ReadDbFile: %v", err)
+	}
+	if hdr.Version != 0 || hdr.ChunkSize != CHUNKSIZE {
+		t.Errorf("wrong legacy header: %+v", hdr)
+	}
+}
+
+// Die DB wird über eine temporäre Datei geschrieben, optional bleibt die alte DB als .bak erhalten.
+func TestWriteDbFileBackup(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "database.test")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "index.db")
+
+	// erste DB: kein .bak, weil es keine vorherige DB gibt
+	if err := WriteDbFile(path, key, NewDbHeader(), db, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".bak"); err == nil {
+		t.Errorf("there should be no backup of a missing db")
+	}
+
+	// zweite DB
+	newdb := SfDb{".": SfFile{Mtime: 42}}
+	if err := WriteDbFile(path, key, NewDbHeader(), newdb, true); err != nil {
+		t.Fatal(err)
+	}
+	if readdb, _ := DbFromFile(path, key); !reflect.DeepEqual(readdb, newdb) {
+		t.Errorf("wrong db: %v", readdb)
+	}
+	if readdb, _ := DbFromFile(path+".bak", key); !reflect.DeepEqual(readdb, db) {
+		t.Errorf("wrong backup: %v", readdb)
+	}
+
+	// keine temporären Dateien
+	if infos, _ := ioutil.ReadDir(dir); len(infos) != 2 {
+		t.Errorf("only index.db and index.db.bak should exist: %d", len(infos))
+	}
+}
+
+// Die Chunkgröße im Kopf wird geprüft.
+func TestDbFileChunkSize(t *testing.T) {
+	hdr := NewDbHeader()
+	hdr.ChunkSize = 131072 * 8
+	if err := WriteDbFile(writeTestFile, key, hdr, db, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, readHdr, err := ReadDbFile(writeTestFile, key); err != nil || readHdr.ChunkSize != hdr.ChunkSize {
+		t.Errorf("ReadDbFile: %+v, %v", readHdr, err)
+	}
+
+	hdr.ChunkSize = 1000
+	if err := WriteDbFile(writeTestFile, key, hdr, db, false); err == nil {
+		t.Errorf("WriteDbFile with invalid chunk size should fail")
+	}
+}
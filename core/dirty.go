@@ -0,0 +1,31 @@
+package core
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// dirtySet enthält die geänderten Pfade (relativ zum Root) für ScanPaths.
+type dirtySet map[string]bool
+
+func newDirtySet(paths []string) dirtySet {
+	d := make(dirtySet, len(paths))
+	for _, p := range paths {
+		d[filepath.Clean(p)] = true
+	}
+	return d
+}
+
+// touches prüft, ob relPath selbst, ein Element darin oder ein Ordner darüber geändert wurde.
+func (d dirtySet) touches(relPath string) bool {
+	if d[relPath] || d["."] {
+		return true
+	}
+	sep := string(filepath.Separator)
+	for p := range d {
+		if strings.HasPrefix(p, relPath+sep) || strings.HasPrefix(relPath, p+sep) || relPath == "." {
+			return true
+		}
+	}
+	return false
+}
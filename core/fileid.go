@@ -0,0 +1,31 @@
+package core
+
+import (
+	"fmt"
+	"os"
+)
+
+// fileID beschreibt den Stand einer Datei. Ändert er sich während dem Scan, dann wurde die Datei verändert.
+type fileID struct {
+	size  int64
+	mtime int64  // Nanosekunden
+	inode uint64 // 0, wenn das System keine Inodes kennt
+}
+
+func statFileID(path string) (fileID, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileID{}, err
+	}
+	return fileID{size: info.Size(), mtime: info.ModTime().UnixNano(), inode: inodeOf(info)}, nil
+}
+
+// UnstableFileError wird zurück gegeben, wenn sich eine Datei bei jedem Versuch während dem Scan verändert hat.
+type UnstableFileError struct {
+	Path     string
+	Attempts int
+}
+
+func (e *UnstableFileError) Error() string {
+	return fmt.Sprintf("file changed during scan (%d attempts): %s", e.Attempts, e.Path)
+}
@@ -0,0 +1,11 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package core
+
+import "os"
+
+// Windows und andere Systeme ohne Stat_t liefern keine Inode, dann werden nur Größe und Zeit verglichen.
+func inodeOf(info os.FileInfo) uint64 {
+	return 0
+}
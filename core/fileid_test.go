@@ -0,0 +1,76 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStatFileID(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "fileid.test")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "f")
+	ioutil.WriteFile(path, []byte("abc"), 0600)
+
+	id1, _ := statFileID(path)
+	// gleiche Größe und gleiche Zeit, aber eine neue Datei (andere Inode)
+	ioutil.WriteFile(path+".new", []byte("xyz"), 0600)
+	os.Chtimes(path+".new", time.Unix(0, id1.mtime), time.Unix(0, id1.mtime))
+	os.Rename(path+".new", path)
+	id2, _ := statFileID(path)
+	if id1.size != id2.size || id1.mtime != id2.mtime || id1 == id2 {
+		t.Errorf("replaced file not detected: %v %v", id1, id2)
+	}
+	// nur die Zeit (in ns) ändert sich
+	os.Chtimes(path, time.Unix(0, id2.mtime+1), time.Unix(0, id2.mtime+1))
+	if id3, _ := statFileID(path); id3 == id2 {
+		t.Error("mtime change not detected")
+	}
+}
+
+func TestScanFileUnstable(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "fileid.test")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "f")
+	ioutil.WriteFile(path, make([]byte, 40000000), 0600)
+
+	cfg := DefaultScanConfig()
+	cfg.Retries = 2
+	sem := make(chan struct{}, 1)
+	if _, err := scanFileStable(path, cfg, sem, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// die Datei ändert sich ständig
+	stop := make(chan bool)
+	done := make(chan bool)
+	started := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := int64(1); ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				os.Chtimes(path, time.Unix(0, i), time.Unix(0, i))
+			}
+			if i == 1 {
+				close(started)
+			}
+		}
+	}()
+	<-started
+	cfg.Progress = &Progress{}
+	_, err := scanFileStable(path, cfg, sem, false)
+	close(stop)
+	<-done
+	if e, ok := err.(*UnstableFileError); !ok || e.Attempts != 3 {
+		t.Errorf("wrong error: %v", err)
+	}
+	// nur der letzte Versuch wird gezählt
+	if n := cfg.Progress.Status().BytesDone; n != 40000000 {
+		t.Errorf("retries counted twice: %d bytes", n)
+	}
+}
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// CollectGarbage sucht alle Chunks im Store, die von keiner der DBs verwendet werden (verwaiste Chunks).
+// Mit remove werden verwaiste Chunks gelöscht, aber nur wenn ihre mtime älter als grace ist.
+// So werden keine Chunks gelöscht, die gerade erst für eine noch nicht hochgeladene DB geschrieben wurden.
+// Zurück gegeben werden alle verwaisten Chunks (gelöscht oder nicht).
+func CollectGarbage(dbs []SfDb, k KeyFile, store WritableChunkStore, grace time.Duration, remove bool, debug bool) (orphans []ChunkInfo, summary string, retErr error) {
+
+	// Alle verwendeten Chunks aus allen DBs sammeln
+	live := make(map[ChunkHash]bool)
+	for _, db := range dbs {
+		for ch := range db.GetReverseSfDb(k) {
+			live[ch] = true
+		}
+	}
+
+	// Sicherheitsabfrage: eine leere (oder falsche) DB würde alles löschen
+	if len(live) == 0 {
+		retErr = errors.New("no chunks referenced by the given DBs: refusing to collect garbage")
+		return
+	}
+
+	// Chunks im Store auflisten
+	list, retErr := store.ListChunks()
+	if retErr != nil {
+		return
+	}
+
+	var bytesOrphan int64
+	countRemoved := 0
+	deadline := time.Now().Add(-grace)
+	for _, info := range list {
+		if live[info.Name] {
+			continue
+		}
+		orphans = append(orphans, info)
+		bytesOrphan += info.Size
+
+		// löschen?
+		if !remove || info.Mtime.After(deadline) {
+			continue
+		}
+		scanDebug(debug, fmt.Sprintf("remove chunk %x", info.Name))
+		if retErr = store.RemoveChunk(info.Name[:]); retErr != nil {
+			break
+		}
+		countRemoved++
+	}
+
+	// Statistik
+	summary = fmt.Sprintf("GC: error=%v, chunks=%d, live=%d, orphans=%d, orphanBytes=%d, removed=%d", retErr, len(list), len(live), len(orphans), bytesOrphan, countRemoved)
+	return
+}
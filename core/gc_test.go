@@ -0,0 +1,64 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	k := LoadKeyfile(testKeyFile)
+
+	// zwei Dateien hochladen
+	rootdir, _ := ioutil.TempDir("", "gc.test")
+	defer os.RemoveAll(rootdir)
+	ioutil.WriteFile(filepath.Join(rootdir, "a.txt"), []byte("bleibt"), 0600)
+	ioutil.WriteFile(filepath.Join(rootdir, "b.txt"), []byte("wird gelöscht"), 0600)
+	oldDb, _, _, _ := ScanFolder(rootdir, SfDb{}, false)
+
+	dir := newTestChunkDir(t)
+	defer os.RemoveAll(dir)
+	store, _ := NewDirChunkStore(dir)
+	PushChunks(oldDb, k, rootdir, store, false)
+
+	// eine Datei löschen
+	os.Remove(filepath.Join(rootdir, "b.txt"))
+	newDb, _, _, _ := ScanFolder(rootdir, oldDb, false)
+
+	// mit der alten DB gibt es keine verwaisten Chunks
+	orphans, _, err := CollectGarbage([]SfDb{newDb, oldDb}, k, store, 0, true, false)
+	if err != nil || len(orphans) != 0 {
+		t.Errorf("gc with old db: %v, %v", orphans, err)
+	}
+
+	// innerhalb der grace period wird nichts gelöscht
+	orphans, _, err = CollectGarbage([]SfDb{newDb}, k, store, time.Hour, true, false)
+	if err != nil || len(orphans) != 1 {
+		t.Errorf("gc grace: %v, %v", orphans, err)
+	}
+	if list, _ := store.ListChunks(); len(list) != 2 {
+		t.Errorf("gc grace removed chunk")
+	}
+
+	// jetzt wird gelöscht
+	orphans, _, err = CollectGarbage([]SfDb{newDb}, k, store, 0, true, false)
+	h := oldDb["b.txt"].FileChunks[0]
+	if err != nil || len(orphans) != 1 || orphans[0].Name != toArray(k.CalcChunkCryptHash(h[:])) {
+		t.Errorf("gc: %v, %v", orphans, err)
+	}
+	if list, _ := store.ListChunks(); len(list) != 1 {
+		t.Errorf("gc did not remove chunk")
+	}
+
+	// leere DB: Abbruch
+	if _, _, err := CollectGarbage([]SfDb{{}}, k, store, 0, true, false); err == nil {
+		t.Errorf("gc with empty db should fail")
+	}
+}
+
+func toArray(b []byte) ChunkHash {
+	ch, _ := Sha512ToChunkHash(b)
+	return ch
+}
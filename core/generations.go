@@ -0,0 +1,95 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Ältere Stände der DB (Generationen) liegen im Ordner <dbpath>.generations.
+// Jede Generation ist eine normale DB Datei mit dem Zeitpunkt (UTC, in ms) als Namen, z.B. 20261001T120000.000Z.db
+// Der Name enthält keinen ':', weil das Windows und viele rclone Remotes nicht (oder nur umgeschrieben) erlauben.
+const GenerationTimeFormat = "20060102T150405.000Z"
+
+// Generation ist ein gespeicherter Stand der DB.
+type Generation struct {
+	Name string    // Zeitpunkt im GenerationTimeFormat (ohne .db)
+	Time time.Time // Zeitpunkt (UTC)
+	Path string    // Pfad zur DB Datei
+}
+
+// GenerationDir gibt den Ordner mit den Generationen einer DB zurück.
+func GenerationDir(dbpath string) string {
+	return dbpath + ".generations"
+}
+
+// GenerationPath gibt den Pfad zur Generation name zurück.
+// Ist name kein gültiger Zeitpunkt, dann wird ein Fehler zurück gegeben.
+func GenerationPath(dbpath string, name string) (string, error) {
+	if _, err := time.Parse(GenerationTimeFormat, name); err != nil {
+		return "", err
+	}
+	return filepath.Join(GenerationDir(dbpath), name+".db"), nil
+}
+
+// SaveGeneration speichert die DB (mit dem Kopf hdr) als Generation mit dem Zeitpunkt t.
+// Gibt es schon eine Generation mit diesem Zeitpunkt, dann wird t um je 1ms erhöht (nichts wird überschrieben).
+func SaveGeneration(dbpath string, key []byte, hdr DbHeader, db SfDb, t time.Time) (Generation, error) {
+	if err := os.MkdirAll(GenerationDir(dbpath), 0700); err != nil {
+		return Generation{}, err
+	}
+	t = t.UTC().Truncate(time.Millisecond)
+	for {
+		name := t.Format(GenerationTimeFormat)
+		path, _ := GenerationPath(dbpath, name)
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			return Generation{Name: name, Time: t, Path: path}, WriteDbFile(path, key, hdr, db, false)
+		}
+		t = t.Add(time.Millisecond)
+	}
+}
+
+// ListGenerations listet alle Generationen einer DB auf (die älteste zuerst).
+// Gibt es keinen Ordner mit Generationen, dann ist die Liste leer.
+func ListGenerations(dbpath string) ([]Generation, error) {
+	infos, err := ioutil.ReadDir(GenerationDir(dbpath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var gens []Generation
+	for _, info := range infos {
+		name := strings.TrimSuffix(info.Name(), ".db")
+		t, err := time.Parse(GenerationTimeFormat, name)
+		if err != nil || info.IsDir() || name == info.Name() {
+			continue // fremde Dateien, z.B. temporäre Dateien von WriteDbFile
+		}
+		gens = append(gens, Generation{Name: name, Time: t, Path: filepath.Join(GenerationDir(dbpath), info.Name())})
+	}
+	sort.Slice(gens, func(a, b int) bool {
+		return gens[a].Time.Before(gens[b].Time)
+	})
+	return gens, nil
+}
+
+// PruneGenerations löscht die ältesten Generationen, sodass nur noch keep übrig bleiben.
+// Zurück gegeben werden die gelöschten Generationen.
+func PruneGenerations(dbpath string, keep int) ([]Generation, error) {
+	gens, err := ListGenerations(dbpath)
+	if err != nil || len(gens) <= keep {
+		return nil, err
+	}
+	removed := gens[:len(gens)-keep]
+	for i, g := range removed {
+		if err := os.Remove(g.Path); err != nil {
+			return removed[:i], err
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,69 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGenerations(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "generations.test")
+	defer os.RemoveAll(dir)
+	dbpath := filepath.Join(dir, "index.db")
+
+	// keine Generationen
+	if gens, err := ListGenerations(dbpath); err != nil || len(gens) != 0 {
+		t.Errorf("ListGenerations without folder: %v, %v", gens, err)
+	}
+
+	// drei Generationen (nicht in zeitlicher Reihenfolge)
+	start := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	for _, h := range []int{2, 0, 1} {
+		g, err := SaveGeneration(dbpath, key, NewDbHeader(), SfDb{".": SfFile{Mtime: uint64(h)}}, start.Add(time.Duration(h)*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h == 0 && g.Name != "20261001T120000.000Z" {
+			t.Errorf("wrong generation name: %s", g.Name)
+		}
+	}
+	ioutil.WriteFile(filepath.Join(GenerationDir(dbpath), "README"), nil, 0600) // wird ignoriert
+
+	gens, err := ListGenerations(dbpath)
+	if err != nil || len(gens) != 3 {
+		t.Fatalf("ListGenerations: %v, %v", gens, err)
+	}
+	for i, g := range gens {
+		gdb, err := DbFromFile(g.Path, key)
+		if err != nil || !reflect.DeepEqual(gdb, SfDb{".": SfFile{Mtime: uint64(i)}}) {
+			t.Errorf("generation %s: %v, %v", g.Name, gdb, err)
+		}
+	}
+
+	// die älteste löschen
+	removed, err := PruneGenerations(dbpath, 2)
+	if err != nil || len(removed) != 1 || removed[0].Name != gens[0].Name {
+		t.Errorf("PruneGenerations: %v, %v", removed, err)
+	}
+	if gens, _ := ListGenerations(dbpath); len(gens) != 2 {
+		t.Errorf("two generations should be left: %d", len(gens))
+	}
+
+	// zweimal derselbe Zeitpunkt: nichts wird überschrieben
+	g1, err1 := SaveGeneration(dbpath, key, NewDbHeader(), SfDb{}, start)
+	g2, err2 := SaveGeneration(dbpath, key, NewDbHeader(), SfDb{}, start)
+	if err1 != nil || err2 != nil || g1.Name == g2.Name || !g2.Time.After(g1.Time) {
+		t.Errorf("same time: %v, %v", g1, g2)
+	}
+	if gens, _ := ListGenerations(dbpath); len(gens) != 4 {
+		t.Errorf("four generations expected: %d", len(gens))
+	}
+
+	// ungültige Namen
+	if _, err := GenerationPath(dbpath, "../index"); err == nil {
+		t.Errorf("GenerationPath should fail for invalid names")
+	}
+}
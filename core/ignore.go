@@ -0,0 +1,187 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// In jedem Ordner kann eine Datei mit diesem Namen liegen. Sie enthält Muster wie eine .gitignore
+// und gilt für den Ordner und alle Unterordner.
+const IgnoreFileName = ".splitfuseignore"
+
+// ignoreRule ist ein Muster aus --exclude oder einer .splitfuseignore Datei.
+type ignoreRule struct {
+	base     string   // Ordner (relativ zum Root, mit /), für den das Muster gilt ("." für --exclude)
+	segments []string // Muster, an / geteilt
+	negate   bool     // !muster: wieder einschließen
+	dirOnly  bool     // muster/: nur Ordner
+	anchored bool     // das Muster enthält ein /: gilt relativ zu base, sonst für den Namen in jeder Tiefe
+}
+
+// parseIgnoreRule liest ein Muster (Syntax wie in .gitignore).
+// Leere Zeilen und Kommentare ergeben ok == false.
+func parseIgnoreRule(base string, line string) (rule ignoreRule, ok bool, err error) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false, nil
+	}
+	rule.base = base
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimLeft(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false, nil
+	}
+	rule.segments = strings.Split(line, "/")
+	for _, s := range rule.segments {
+		if _, err := path.Match(s, ""); err != nil {
+			return ignoreRule{}, false, err
+		}
+	}
+	return rule, true, nil
+}
+
+// match prüft, ob das Muster auf relPath (relativ zum Root, mit /) passt.
+func (r ignoreRule) match(relPath string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.base != "." {
+		if !strings.HasPrefix(relPath, r.base+"/") {
+			return false
+		}
+		relPath = relPath[len(r.base)+1:]
+	}
+	if !r.anchored {
+		ok, _ := path.Match(r.segments[0], path.Base(relPath))
+		return ok
+	}
+	return matchSegments(r.segments, strings.Split(relPath, "/"))
+}
+
+// matchSegments vergleicht ein Muster mit einem Pfad, Teil für Teil. ** passt auf beliebig viele Teile.
+func matchSegments(pattern []string, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// scanFilter entscheidet, welche Elemente beim Scan übersprungen werden.
+// Die .splitfuseignore Dateien werden beim ersten Zugriff auf einen Ordner gelesen.
+type scanFilter struct {
+	root  string
+	rules map[string][]ignoreRule // Ordner (relativ, mit /) -> alle Muster, die dort gelten
+	errs  *scanErrors             // ungültige Muster in .splitfuseignore Dateien (im tolerant Modus übersprungen)
+}
+
+// newScanFilter erstellt einen Filter mit den --exclude Mustern.
+func newScanFilter(root string, exclude []string, errs *scanErrors) (*scanFilter, error) {
+	f := &scanFilter{root: root, rules: make(map[string][]ignoreRule), errs: errs}
+	var rules []ignoreRule
+	for _, p := range exclude {
+		rule, ok, err := parseIgnoreRule(".", p)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			rules = append(rules, rule)
+		}
+	}
+	f.rules[""] = rules // nur die --exclude Muster (ohne .splitfuseignore im Root)
+	return f, nil
+}
+
+// rulesFor gibt alle Muster zurück, die in einem Ordner gelten (die des Elternordners und die eigenen).
+func (f *scanFilter) rulesFor(relDir string) ([]ignoreRule, error) {
+	if rules, ok := f.rules[relDir]; ok {
+		return rules, nil
+	}
+	parent := ""
+	if relDir != "." {
+		parent = path.Dir(relDir)
+	}
+	inherited, err := f.rulesFor(parent)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := inherited
+	fh, err := os.Open(filepath.Join(f.root, filepath.FromSlash(relDir), IgnoreFileName))
+	if err == nil {
+		defer fh.Close()
+		rules = append([]ignoreRule(nil), inherited...)
+		scanner := bufio.NewScanner(fh)
+		for line := 1; scanner.Scan(); line++ {
+			rule, ok, err := parseIgnoreRule(relDir, scanner.Text())
+			if err != nil {
+				// ungültiges Muster: als datei:zeile melden (im tolerant Modus wird nur die Zeile übersprungen)
+				loc := fmt.Sprintf("%s:%d", path.Join(relDir, IgnoreFileName), line)
+				if err := f.errs.add(filepath.FromSlash(loc), err); err != nil {
+					return nil, fmt.Errorf("%s: %v", loc, err)
+				}
+				continue
+			}
+			if ok {
+				rules = append(rules, rule)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f.rules[relDir] = rules
+	return rules, nil
+}
+
+// excluded prüft, ob ein Element übersprungen wird. Das letzte passende Muster entscheidet.
+// relPath ist relativ zum Root (mit dem Trennzeichen des Systems). Der Root selbst wird nie übersprungen.
+func (f *scanFilter) excluded(relPath string, isDir bool) (bool, error) {
+	if f == nil || relPath == "." {
+		return false, nil
+	}
+	relPath = filepath.ToSlash(relPath)
+	rules, err := f.rulesFor(path.Dir(relPath))
+	if err != nil {
+		return false, err
+	}
+	excluded := false
+	for _, r := range rules {
+		if r.match(relPath, isDir) {
+			excluded = !r.negate
+		}
+	}
+	return excluded, nil
+}
@@ -0,0 +1,111 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIgnoreRule(t *testing.T) {
+	tests := []struct {
+		base, pattern, path string
+		isDir, match        bool
+	}{
+		{".", "*.swp", "a/b/.x.swp", false, true},
+		{".", "*.swp", "a/b.swp/c", false, false},
+		{".", "lost+found/", "lost+found", true, true},
+		{".", "lost+found/", "lost+found", false, false},
+		{".", "/tmp", "tmp", false, true},
+		{".", "/tmp", "a/tmp", false, false},
+		{".", "a/*/c", "a/b/c", false, true},
+		{".", "a/**/c", "a/c", false, true},
+		{".", "a/**/c", "a/b/d/c", false, true},
+		{".", "**/c", "x/c", true, true},
+		{"sub", "/tmp", "sub/tmp", false, true},
+		{"sub", "/tmp", "tmp", false, false},
+		{"sub", "*.part", "other/x.part", false, false},
+	}
+	for _, tt := range tests {
+		rule, ok, err := parseIgnoreRule(tt.base, tt.pattern)
+		if !ok || err != nil {
+			t.Fatalf("%s: %v", tt.pattern, err)
+		}
+		if m := rule.match(tt.path, tt.isDir); m != tt.match {
+			t.Errorf("%s/%s on %s: %v", tt.base, tt.pattern, tt.path, m)
+		}
+	}
+	if _, ok, _ := parseIgnoreRule(".", "# comment"); ok {
+		t.Error("comment should be ignored")
+	}
+	if _, _, err := parseIgnoreRule(".", "[a"); err == nil {
+		t.Error("bad pattern should fail")
+	}
+}
+
+func TestScanFolderExclude(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "ignore.test")
+	defer os.RemoveAll(dir)
+
+	for _, p := range []string{"keep.txt", ".file.swp", "sub/keep.txt", "sub/x.part", "sub/important.part", ".Trash-1000/old.txt", "sub/deep/x.part"} {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(p)), 0700)
+		ioutil.WriteFile(filepath.Join(dir, p), []byte(p), 0600)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "sub", IgnoreFileName), []byte("# temp downloads\n*.part\n!important.part\n"), 0600)
+
+	cfg := DefaultScanConfig()
+	cfg.Exclude = []string{"*.swp", ".Trash-*/"}
+	db, _, _, err := ScanFolderWithConfig(dir, SfDb{}, cfg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"keep.txt", "sub/keep.txt", "sub/important.part", "sub/" + IgnoreFileName, "sub/deep"} {
+		if _, ok := db[filepath.FromSlash(p)]; !ok {
+			t.Errorf("%s is missing", p)
+		}
+	}
+	for _, p := range []string{".file.swp", "sub/x.part", ".Trash-1000", ".Trash-1000/old.txt", "sub/deep/x.part"} {
+		if _, ok := db[filepath.FromSlash(p)]; ok {
+			t.Errorf("%s should be excluded", p)
+		}
+	}
+	for _, c := range append(db["."].FolderContent, db["sub"].FolderContent...) {
+		if c.Name == ".file.swp" || c.Name == ".Trash-1000" || c.Name == "x.part" {
+			t.Errorf("%s should not be in FolderContent", c.Name)
+		}
+	}
+
+	// ein neues Muster entfernt die Elemente aus der alten DB
+	cfg.Exclude = append(cfg.Exclude, "keep.txt")
+	db, changed, _, err := ScanFolderWithConfig(dir, db, cfg, false)
+	if _, ok := db["keep.txt"]; ok || !changed || err != nil {
+		t.Errorf("keep.txt should be removed: changed=%v, err=%v", changed, err)
+	}
+}
+
+func TestScanFolderBadIgnoreFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "ignore.test")
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "sub"), 0700)
+	ioutil.WriteFile(filepath.Join(dir, "sub", "x.part"), []byte("x"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "sub", IgnoreFileName), []byte("*.part\n[a\n"), 0600)
+	where := filepath.Join("sub", IgnoreFileName) + ":2"
+
+	// ohne tolerant Modus wird mit Datei und Zeile abgebrochen
+	cfg := DefaultScanConfig()
+	if _, _, _, err := ScanFolderWithConfig(dir, SfDb{}, cfg, false); err == nil || !strings.HasPrefix(err.Error(), filepath.ToSlash(where)+": ") {
+		t.Errorf("bad pattern: %v", err)
+	}
+
+	// im tolerant Modus wird nur die Zeile übersprungen
+	cfg.Tolerant = true
+	db, _, _, err := ScanFolderWithConfig(dir, SfDb{}, cfg, false)
+	partial, ok := err.(*PartialScanError)
+	if !ok || len(partial.Errors) != 1 || partial.Errors[0].Path != where {
+		t.Fatalf("tolerant: %v", err)
+	}
+	if _, ok := db[filepath.Join("sub", "x.part")]; ok {
+		t.Error("the other patterns must still apply")
+	}
+}
@@ -91,18 +91,60 @@ func CryptBytes(data []byte, offset int64, chunkKey []byte) {
 	stream.XORKeyStream(data, data)
 }
 
+// CryptReader ver- oder entschlüsselt (AES-CTR, siehe CryptBytes) alle bytes, die aus r gelesen werden.
+// offset gibt an, an welcher Stelle im Chunk die erste gelesene byte steht.
+type CryptReader struct {
+	r        io.Reader
+	offset   int64
+	chunkKey []byte
+}
+
+// NewCryptReader erzeugt einen CryptReader.
+func NewCryptReader(r io.Reader, offset int64, chunkKey []byte) *CryptReader {
+	return &CryptReader{r: r, offset: offset, chunkKey: chunkKey}
+}
+
+// Read liest aus dem darunter liegenden Reader und ver- bzw. entschlüsselt die Daten.
+func (c *CryptReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		CryptBytes(p[:n], c.offset, c.chunkKey)
+		c.offset += int64(n)
+	}
+	return n, err
+}
+
 // Erzeugt ein neues Keyfile das genau 128 random bytes enthält.
 // Existierende Dateien werden NICHT überschrieben.
 // Im Fehlerfall wird mit panic abgebrochen.
 func NewRandomKeyfile(path string) {
 	// random key erzeugen
-	randkey := make([]byte, 128)
-	n, err := io.ReadFull(rand.Reader, randkey)
+	randkey := newRandomKey()
+
+	// existiert die datei schon? -> nicht überschreiben
+	if _, err := os.Stat(path); err == nil {
+		panic("file already exists")
+	}
+
+	// Datei schreiben
+	err := ioutil.WriteFile(path, randkey, 0600)
 	if err != nil {
 		panic(err)
 	}
-	if n != 128 || len(randkey) != 128 {
-		panic("can't create 128 byte key")
+
+	// testweise lesen  (bricht mit panic ab, wenn was nicht stimmt)
+	k := LoadKeyfile(path)
+	k.DbKey()
+}
+
+// NewProtectedKeyfile erzeugt ein neues Keyfile, dessen 128 random bytes mit der Passphrase geschützt sind.
+// Existierende Dateien werden NICHT überschrieben.
+// Im Fehlerfall wird mit panic abgebrochen.
+func NewProtectedKeyfile(path string, passphrase []byte) {
+	// random key erzeugen und schützen
+	wrapped, err := WrapKeyBytes(newRandomKey(), passphrase)
+	if err != nil {
+		panic(err)
 	}
 
 	// existiert die datei schon? -> nicht überschreiben
@@ -111,22 +153,47 @@ func NewRandomKeyfile(path string) {
 	}
 
 	// Datei schreiben
-	err = ioutil.WriteFile(path, randkey, 0600)
+	err = ioutil.WriteFile(path, wrapped, 0600)
 	if err != nil {
 		panic(err)
 	}
 
 	// testweise lesen  (bricht mit panic ab, wenn was nicht stimmt)
-	k := LoadKeyfile(path)
+	k := LoadKeyfileWithPassphrase(path, passphrase)
 	k.DbKey()
 }
 
-// LoadKeyfile lädt das Keyfile (genau 128 bytes groß) und generiert daraus die Schlüssel.
+// newRandomKey erzeugt 128 random bytes. Im Fehlerfall wird mit panic abgebrochen.
+func newRandomKey() []byte {
+	randkey := make([]byte, 128)
+	n, err := io.ReadFull(rand.Reader, randkey)
+	if err != nil {
+		panic(err)
+	}
+	if n != 128 || len(randkey) != 128 {
+		panic("can't create 128 byte key")
+	}
+	return randkey
+}
+
+// LoadKeyfile lädt das Keyfile und generiert daraus die Schlüssel.
+// Das Keyfile ist entweder genau 128 bytes groß (ungeschützt) oder mit einer Passphrase geschützt (siehe WrapKeyBytes).
+// Die Passphrase für ein geschütztes Keyfile kommt aus PassphraseFile, SPLITFUSE_PASSPHRASE oder vom Terminal.
 // Im Fehlerfall wird mit panic abgebrochen.
 //   cryptSecret: Daraus wird der individuelle Chunk Schlüssel für die Verschlüsselung (AES-256-CTR) abgeleitet.
 //   hashSecret: Daraus wird der individuelle ChunkCryptHash für den Chunk Dateiname abgeleitet.
 //   indexSecret: Damit wird die DB verschlüsselt.
 func LoadKeyfile(path string) KeyFile {
+	return loadKeyfile(path, currentPassphrase())
+}
+
+// LoadNewKeyfile lädt ein Keyfile wie LoadKeyfile, die Passphrase kommt aber aus NewPassphraseFile,
+// SPLITFUSE_NEW_PASSPHRASE oder vom Terminal (z.B. für das neue Keyfile bei rekey).
+func LoadNewKeyfile(path string) KeyFile {
+	return loadKeyfile(path, newPassphrase())
+}
+
+func loadKeyfile(path string, source passphraseSource) KeyFile {
 
 	// Schlüsseldatei einlesen und im Fehlerfall mit panic abbrechen
 	filebytes, err := ioutil.ReadFile(path)
@@ -134,6 +201,38 @@ func LoadKeyfile(path string) KeyFile {
 		panic(err)
 	}
 
+	// geschütztes Keyfile: Passphrase holen
+	if IsProtectedKeyfile(filebytes) {
+		passphrase, err := source.read("Passphrase for " + path + ": ")
+		if err != nil {
+			panic(err)
+		}
+		return LoadKeyfileWithPassphrase(path, passphrase)
+	}
+
+	return keyFromBytes(filebytes)
+}
+
+// LoadKeyfileWithPassphrase lädt ein Keyfile, das mit einer Passphrase geschützt ist.
+// Ungeschützte Keyfiles (128 bytes) werden auch akzeptiert, dann wird die Passphrase ignoriert.
+// Im Fehlerfall (z.B. falsche Passphrase) wird mit panic abgebrochen.
+func LoadKeyfileWithPassphrase(path string, passphrase []byte) KeyFile {
+	filebytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		panic(err)
+	}
+	if IsProtectedKeyfile(filebytes) {
+		filebytes, err = UnwrapKeyBytes(filebytes, passphrase)
+		if err != nil {
+			panic(err)
+		}
+	}
+	return keyFromBytes(filebytes)
+}
+
+// keyFromBytes generiert die Schlüssel aus den 128 bytes des Keyfiles.
+func keyFromBytes(filebytes []byte) KeyFile {
+
 	// In der Datei müssen genau 128 bytes sein, sonst abbruch mit panic.
 	readlen := len(filebytes)
 	if readlen != 128 {
@@ -5,6 +5,9 @@ import (
 	"encoding/hex"
 	"bytes"
 	"os"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
 )
 
 var (
@@ -137,3 +140,111 @@ func TestCryptBytes(t *testing.T) {
 	}
 
 }
+
+// Ein geschütztes Keyfile muss mit der richtigen Passphrase die gleichen Schlüssel liefern.
+func TestProtectedKeyfile(t *testing.T) {
+	raw, _ := ioutil.ReadFile(testKeyFile)
+	wrapped, err := WrapKeyBytes(raw, []byte("geheim"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsProtectedKeyfile(wrapped) || IsProtectedKeyfile(raw) {
+		t.Fatalf("IsProtectedKeyfile is wrong")
+	}
+
+	// richtige Passphrase
+	unwrapped, err := UnwrapKeyBytes(wrapped, []byte("geheim"))
+	if err != nil || !bytes.Equal(unwrapped, raw) {
+		t.Errorf("UnwrapKeyBytes: %v", err)
+	}
+
+	// falsche Passphrase
+	if _, err := UnwrapKeyBytes(wrapped, []byte("falsch")); err == nil {
+		t.Errorf("UnwrapKeyBytes with wrong passphrase should fail")
+	}
+
+	// veränderter Header (z.B. kleinere scrypt Parameter)
+	tampered := append([]byte{}, wrapped...)
+	tampered[10]--
+	if _, err := UnwrapKeyBytes(tampered, []byte("geheim")); err == nil {
+		t.Errorf("UnwrapKeyBytes with tampered header should fail")
+	}
+
+	// zu teure scrypt Parameter werden gar nicht erst berechnet
+	for _, i := range []int{10, 11, 12} {
+		tampered := append([]byte{}, wrapped...)
+		tampered[i] = 255
+		if _, err := UnwrapKeyBytes(tampered, []byte("geheim")); err == nil || !strings.Contains(err.Error(), "invalid scrypt") {
+			t.Errorf("scrypt parameter %d: %v", i, err)
+		}
+	}
+
+	// über die Datei laden
+	file, _ := ioutil.TempFile("", "keyfile.test")
+	file.Write(wrapped)
+	file.Close()
+	defer os.Remove(file.Name())
+	k1 := LoadKeyfile(testKeyFile)
+	k2 := LoadKeyfileWithPassphrase(file.Name(), []byte("geheim"))
+	if !bytes.Equal(k1.DbKey(), k2.DbKey()) {
+		t.Errorf("protected key file gives different keys")
+	}
+}
+
+// Keyfile neu schützen: alte und neue Passphrase kommen aus verschiedenen Quellen
+func TestRewrapKeyfile(t *testing.T) {
+	defer func() { PassphraseFile, NewPassphraseFile = "", "" }()
+	raw, _ := ioutil.ReadFile(testKeyFile)
+	k1 := LoadKeyfile(testKeyFile)
+
+	dir, _ := ioutil.TempDir("", "keyfile.test")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keyfile")
+	ioutil.WriteFile(path, raw, 0600)
+	oldPw, newPw := filepath.Join(dir, "old"), filepath.Join(dir, "new")
+	ioutil.WriteFile(oldPw, []byte("alt\n"), 0600)
+	ioutil.WriteFile(newPw, []byte("neu\n"), 0600)
+	NewPassphraseFile = newPw
+
+	// ungeschützt -> geschützt
+	if err := RewrapKeyfile(path, true); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(path)
+	k2 := LoadNewKeyfile(path)
+	if !IsProtectedKeyfile(data) || !bytes.Equal(k2.DbKey(), k1.DbKey()) {
+		t.Fatalf("keyfile not protected with the new passphrase")
+	}
+
+	// neue Passphrase: die bisherige kommt aus PassphraseFile
+	ioutil.WriteFile(oldPw, []byte("neu\n"), 0600)
+	ioutil.WriteFile(newPw, []byte("anders\n"), 0600)
+	PassphraseFile = oldPw
+	if err := RewrapKeyfile(path, true); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = ioutil.ReadFile(path)
+	if _, err := UnwrapKeyBytes(data, []byte("anders")); err != nil {
+		t.Errorf("new passphrase not used: %v", err)
+	}
+
+	// falsche bisherige Passphrase: die Datei bleibt unverändert
+	if err := RewrapKeyfile(path, false); err == nil {
+		t.Errorf("wrong passphrase should fail")
+	}
+	if now, _ := ioutil.ReadFile(path); !bytes.Equal(now, data) {
+		t.Errorf("keyfile changed after an error")
+	}
+
+	// Schutz entfernen
+	ioutil.WriteFile(oldPw, []byte("anders"), 0600)
+	if err := RewrapKeyfile(path, false); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ = ioutil.ReadFile(path); !bytes.Equal(data, raw) {
+		t.Errorf("unwrapped keyfile differs")
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("wrong mode: %v", info.Mode())
+	}
+}
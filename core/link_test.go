@@ -0,0 +1,146 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScanFolderLinks(t *testing.T) {
+	k := LoadKeyfile(testKeyFile)
+
+	// Links auf einen Ordner, eine Datei und ein nicht vorhandenes Ziel
+	rootdir, _ := ioutil.TempDir("", "link.test")
+	defer os.RemoveAll(rootdir)
+	os.Mkdir(filepath.Join(rootdir, "dir"), 0755)
+	ioutil.WriteFile(filepath.Join(rootdir, "dir", "a.txt"), []byte("datei a"), 0600)
+	os.Symlink("dir", filepath.Join(rootdir, "dirlink"))
+	os.Symlink("dir/a.txt", filepath.Join(rootdir, "filelink"))
+	os.Symlink("missing", filepath.Join(rootdir, "dangling"))
+
+	db, changed, _, err := ScanFolder(rootdir, SfDb{}, false)
+	if err != nil || !changed {
+		t.Fatal(err)
+	}
+	want := map[string]string{"dirlink": "dir", "filelink": "dir/a.txt", "dangling": "missing"}
+	for p, target := range want {
+		e, ok := db[p]
+		if !ok || !e.IsLink || e.IsFile || e.LinkTarget != target || len(e.FileChunks) != 0 {
+			t.Errorf("%s: wrong entry: %+v", p, e)
+		}
+	}
+	if _, ok := db[filepath.Join("dirlink", "a.txt")]; ok {
+		t.Errorf("links must not be followed")
+	}
+	for _, c := range db["."].FolderContent {
+		if _, ok := want[c.Name]; ok != c.IsLink || (ok && c.IsFile) {
+			t.Errorf("wrong folder content: %+v", c)
+		}
+	}
+
+	// ein neues Ziel wird erkannt
+	os.Remove(filepath.Join(rootdir, "dangling"))
+	os.Symlink("dir", filepath.Join(rootdir, "dangling"))
+	db, changed, _, err = ScanFolder(rootdir, db, false)
+	if err != nil || !changed || db["dangling"].LinkTarget != "dir" {
+		t.Errorf("new link target not detected: %+v", db["dangling"])
+	}
+
+	// Wiederherstellen: die Links werden neu angelegt
+	dir := newTestChunkDir(t)
+	defer os.RemoveAll(dir)
+	store, _ := NewDirChunkStore(dir)
+	PushChunks(db, k, rootdir, store, false)
+	target, _ := ioutil.TempDir("", "link.test")
+	defer os.RemoveAll(target)
+	summary, err := RestoreFiles(db, k, store, target, "", false)
+	if err != nil || !strings.Contains(summary, "folders=2, files=4") {
+		t.Errorf("restore: %s", summary)
+	}
+	for p, dest := range map[string]string{"dirlink": "dir", "filelink": "dir/a.txt", "dangling": "dir"} {
+		if got, err := os.Readlink(filepath.Join(target, p)); err != nil || got != dest {
+			t.Errorf("%s: wrong link: %q %v", p, got, err)
+		}
+	}
+
+	// nochmal: alles wird übersprungen
+	summary, err = RestoreFiles(db, k, store, target, "", false)
+	if err != nil || !strings.Contains(summary, "files=0, skipped=4") {
+		t.Errorf("restore again: %s", summary)
+	}
+}
+
+// Links aus einem früheren Lauf werden beim Wiederherstellen ersetzt und nicht verfolgt
+func TestRestoreOverLinks(t *testing.T) {
+	k := LoadKeyfile(testKeyFile)
+
+	rootdir, _ := ioutil.TempDir("", "link.test")
+	defer os.RemoveAll(rootdir)
+	os.Mkdir(filepath.Join(rootdir, "d"), 0755)
+	ioutil.WriteFile(filepath.Join(rootdir, "d", "b.txt"), []byte("datei b"), 0600)
+	ioutil.WriteFile(filepath.Join(rootdir, "f.txt"), []byte("datei f"), 0444)
+	db, _, _, _ := ScanFolder(rootdir, SfDb{}, false)
+	dir := newTestChunkDir(t)
+	defer os.RemoveAll(dir)
+	store, _ := NewDirChunkStore(dir)
+	PushChunks(db, k, rootdir, store, false)
+
+	// im Ziel liegen Links nach außen, wo jetzt eine Datei und ein Ordner sein sollen
+	outside, _ := ioutil.TempDir("", "link.test")
+	defer os.RemoveAll(outside)
+	ioutil.WriteFile(filepath.Join(outside, "victim"), []byte("bleibt"), 0600)
+	target, _ := ioutil.TempDir("", "link.test")
+	defer os.RemoveAll(target)
+	os.Symlink(filepath.Join(outside, "victim"), filepath.Join(target, "f.txt"))
+	os.Symlink(outside, filepath.Join(target, "d"))
+
+	if summary, err := RestoreFiles(db, k, store, target, "", false); err != nil {
+		t.Fatalf("restore: %v (%s)", err, summary)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(outside, "victim")); string(data) != "bleibt" {
+		t.Errorf("link target was overwritten: %s", data)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "b.txt")); err == nil {
+		t.Errorf("file was written through a folder link")
+	}
+	for _, p := range []string{"f.txt", "d"} {
+		if info, err := os.Lstat(filepath.Join(target, p)); err != nil || info.Mode()&os.ModeSymlink != 0 {
+			t.Errorf("%s should be replaced: %v", p, err)
+		}
+	}
+
+	// geänderte Datei mit 0444 aus dem ersten Lauf wird überschrieben
+	ioutil.WriteFile(filepath.Join(target, "f.txt"), []byte("alt"), 0444)
+	if _, err := RestoreFiles(db, k, store, target, "f.txt", false); err != nil {
+		t.Error(err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(target, "f.txt")); string(data) != "datei f" {
+		t.Errorf("wrong content: %s", data)
+	}
+	if info, _ := os.Stat(filepath.Join(target, "f.txt")); info.Mode().Perm() != 0444 {
+		t.Errorf("wrong mode: %v", info.Mode())
+	}
+
+	// ein Ordner mit Inhalt an der Stelle einer Datei wird nicht gelöscht
+	os.Remove(filepath.Join(target, "f.txt"))
+	os.Mkdir(filepath.Join(target, "f.txt"), 0755)
+	ioutil.WriteFile(filepath.Join(target, "f.txt", "keep"), []byte("bleibt"), 0600)
+	if _, err := RestoreFiles(db, k, store, target, "f.txt", false); err == nil {
+		t.Errorf("non-empty folder should not be replaced")
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(target, "f.txt", "keep")); string(data) != "bleibt" {
+		t.Errorf("user data in the target was deleted")
+	}
+
+	// ein Link als Ordner über dem Startpunkt wird nicht verfolgt
+	os.RemoveAll(filepath.Join(target, "d"))
+	os.Symlink(outside, filepath.Join(target, "d"))
+	if _, err := RestoreFiles(db, k, store, target, filepath.Join("d", "b.txt"), false); err == nil {
+		t.Errorf("restore through a linked parent folder should fail")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "b.txt")); err == nil {
+		t.Errorf("file was written through a linked parent folder")
+	}
+}
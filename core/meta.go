@@ -0,0 +1,80 @@
+package core
+
+import (
+	"os"
+	"time"
+)
+
+// Rechte für Einträge ohne POSIX Metadaten (ältere DBs)
+const (
+	defaultFileMode   = 0644
+	defaultFolderMode = 0755
+)
+
+// applyMeta übernimmt die POSIX Metadaten (Rechte, Besitzer und die Zeiten in ns) aus info.
+func applyMeta(e *SfFile, info os.FileInfo) {
+	mtime := info.ModTime()
+	uid, gid, ctime := statOwner(info)
+
+	e.HasMeta = true
+	e.Mode = unixMode(info.Mode())
+	e.Uid = uid
+	e.Gid = gid
+	e.Mtime = uint64(mtime.Unix())
+	e.MtimeNsec = uint32(mtime.Nanosecond())
+	e.Ctime = uint64(ctime.Unix())
+	e.CtimeNsec = uint32(ctime.Nanosecond())
+}
+
+// sameMeta prüft, ob die POSIX Metadaten gleich sind.
+func sameMeta(a SfFile, b SfFile) bool {
+	return a.HasMeta == b.HasMeta && a.Mode == b.Mode && a.Uid == b.Uid && a.Gid == b.Gid &&
+		a.Mtime == b.Mtime && a.MtimeNsec == b.MtimeNsec && a.Ctime == b.Ctime && a.CtimeNsec == b.CtimeNsec
+}
+
+// unixMode wandelt die Rechte (mit setuid, setgid und sticky) in die Bits von chmod um.
+func unixMode(m os.FileMode) uint32 {
+	mode := uint32(m.Perm())
+	if m&os.ModeSetuid != 0 {
+		mode |= 04000
+	}
+	if m&os.ModeSetgid != 0 {
+		mode |= 02000
+	}
+	if m&os.ModeSticky != 0 {
+		mode |= 01000
+	}
+	return mode
+}
+
+// Perm gibt die Rechte als chmod Bits zurück (ohne Metadaten 0644 bzw. 0755).
+func (f SfFile) Perm() uint32 {
+	if f.HasMeta {
+		return f.Mode
+	}
+	if f.IsFile {
+		return defaultFileMode
+	}
+	return defaultFolderMode
+}
+
+// FileMode gibt die Rechte für os.Chmod zurück.
+func (f SfFile) FileMode() os.FileMode {
+	perm := f.Perm()
+	m := os.FileMode(perm & 0777)
+	if perm&04000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if perm&02000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if perm&01000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
+
+// MtimeTime gibt die mtime (mit ns, wenn vorhanden) zurück.
+func (f SfFile) MtimeTime() time.Time {
+	return time.Unix(int64(f.Mtime), int64(f.MtimeNsec))
+}
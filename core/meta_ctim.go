@@ -0,0 +1,14 @@
+//go:build linux || openbsd || dragonfly || solaris || aix
+// +build linux openbsd dragonfly solaris aix
+
+package core
+
+import (
+	"syscall"
+	"time"
+)
+
+// statCtime gibt die ctime aus Stat_t zurück (Feld Ctim).
+func statCtime(st *syscall.Stat_t) time.Time {
+	return time.Unix(int64(st.Ctim.Sec), int64(st.Ctim.Nsec))
+}
@@ -0,0 +1,14 @@
+//go:build darwin || freebsd || netbsd
+// +build darwin freebsd netbsd
+
+package core
+
+import (
+	"syscall"
+	"time"
+)
+
+// statCtime gibt die ctime aus Stat_t zurück (Feld Ctimespec).
+func statCtime(st *syscall.Stat_t) time.Time {
+	return time.Unix(int64(st.Ctimespec.Sec), int64(st.Ctimespec.Nsec))
+}
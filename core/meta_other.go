@@ -0,0 +1,15 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package core
+
+import (
+	"os"
+	"time"
+)
+
+// statOwner gibt Besitzer, Gruppe und ctime einer Datei zurück.
+// Windows und andere Systeme ohne POSIX Besitzer: root und die mtime.
+func statOwner(info os.FileInfo) (uid uint32, gid uint32, ctime time.Time) {
+	return 0, 0, info.ModTime()
+}
@@ -0,0 +1,76 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestScanFolderMeta(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "meta.test")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.txt")
+	ioutil.WriteFile(path, []byte("a"), 0600)
+	os.Chmod(path, 0640|os.ModeSetgid)
+	mtime := time.Unix(1500000000, 123456789)
+	os.Chtimes(path, mtime, mtime)
+
+	db, _, _, err := ScanFolder(dir, SfDb{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := db["a.txt"]
+	if !e.HasMeta || e.Mode != 02640 || e.Uid != uint32(os.Getuid()) || !e.MtimeTime().Equal(mtime) || e.Ctime == 0 {
+		t.Errorf("wrong metadata: %+v", e)
+	}
+	if e.FileMode() != 0640|os.ModeSetgid || !db["."].HasMeta || db["."].Perm()&0700 != 0700 {
+		t.Errorf("wrong mode: %v, %o", e.FileMode(), db["."].Perm())
+	}
+
+	// nur die Rechte ändern: kein neuer Hash, aber eine Änderung
+	fake := db["a.txt"]
+	fake.FileChunks = []ChunkHash{{42}}
+	db["a.txt"] = fake
+	os.Chmod(path, 0600)
+	db, changed, summary, _ := ScanFolder(dir, db, false)
+	if !changed || db["a.txt"].Mode != 0600 || db["a.txt"].FileChunks[0][0] != 42 {
+		t.Errorf("chmod not detected or file hashed again: %s", summary)
+	}
+
+	// nur die ns der mtime ändern: neu hashen
+	mtime = mtime.Add(1)
+	os.Chtimes(path, mtime, mtime)
+	db, changed, _, _ = ScanFolder(dir, db, false)
+	if !changed || db["a.txt"].FileChunks[0][0] == 42 {
+		t.Error("mtime change should rescan the file")
+	}
+
+	// ältere DB ohne Metadaten: wird ergänzt, aber nicht neu gehasht
+	old := SfDb{}
+	for k, v := range db {
+		v.HasMeta, v.Mode, v.Uid, v.Gid, v.MtimeNsec, v.Ctime, v.CtimeNsec = false, 0, 0, 0, 0, 0, 0
+		if v.IsFile {
+			v.FileChunks = []ChunkHash{{43}}
+		}
+		old[k] = v
+	}
+	db, changed, _, _ = ScanFolder(dir, old, false)
+	if !changed || !db["a.txt"].HasMeta || db["a.txt"].FileChunks[0][0] != 43 {
+		t.Error("old db should be upgraded without hashing")
+	}
+	if _, changed, _, _ = ScanFolder(dir, db, false); changed {
+		t.Error("nothing changed")
+	}
+}
+
+func TestSfFilePerm(t *testing.T) {
+	if (SfFile{IsFile: true}).Perm() != 0644 || (SfFile{}).Perm() != 0755 {
+		t.Error("wrong default modes")
+	}
+	e := SfFile{HasMeta: true, Mode: 07755}
+	if e.FileMode() != 0755|os.ModeSetuid|os.ModeSetgid|os.ModeSticky || unixMode(e.FileMode()) != 07755 {
+		t.Errorf("wrong file mode: %v", e.FileMode())
+	}
+}
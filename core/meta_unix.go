@@ -0,0 +1,19 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package core
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// statOwner gibt Besitzer, Gruppe und ctime einer Datei zurück.
+func statOwner(info os.FileInfo) (uid uint32, gid uint32, ctime time.Time) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, info.ModTime()
+	}
+	return st.Uid, st.Gid, statCtime(st)
+}
@@ -0,0 +1,239 @@
+package core
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+// Aufbau eines geschützten Keyfiles:
+//
+//	magic      8 bytes  "SFKEYPW\x00"
+//	version    1 byte   (1)
+//	kdf        1 byte   (1 = scrypt)
+//	logN       1 byte   scrypt Parameter N = 2^logN
+//	r          1 byte   scrypt Parameter r
+//	p          1 byte   scrypt Parameter p
+//	salt      32 bytes
+//	nonce     12 bytes
+//	ciphertext 128 bytes + 16 bytes GCM tag
+//
+// Der Header (alles vor dem ciphertext) ist als additional data mit authentisiert.
+// Der Schlüssel für AES-256-GCM wird mit scrypt aus der Passphrase abgeleitet.
+const (
+	keyfileMagic      = "SFKEYPW\x00"
+	keyfileVersion    = 1
+	keyfileKdfScrypt  = 1
+	keyfileHeaderSize = 8 + 1 + 1 + 3 + 32 + 12
+	keyfileSize       = keyfileHeaderSize + 128 + 16
+
+	// Standardwerte für neue Keyfiles (64 MiB Speicher)
+	scryptLogN = 16
+	scryptR    = 8
+	scryptP    = 1
+)
+
+// PassphraseFile ist der Pfad zu einer Datei mit der Passphrase für geschützte Keyfiles.
+// Ist er leer, dann wird die Umgebungsvariable SPLITFUSE_PASSPHRASE verwendet oder am Terminal nachgefragt.
+var PassphraseFile string
+
+// NewPassphraseFile ist wie PassphraseFile, gilt aber für das neue Keyfile (rekey --new-keyfile und wrapkey).
+// Ist er leer, dann wird die Umgebungsvariable SPLITFUSE_NEW_PASSPHRASE verwendet oder am Terminal nachgefragt.
+var NewPassphraseFile string
+
+// passphraseSource beschreibt, woher eine Passphrase kommt: Datei, Umgebungsvariable oder Terminal.
+type passphraseSource struct {
+	file string
+	env  string
+}
+
+// die Passphrase für alle Keyfiles
+func currentPassphrase() passphraseSource {
+	return passphraseSource{file: PassphraseFile, env: "SPLITFUSE_PASSPHRASE"}
+}
+
+// die Passphrase für das neue Keyfile
+func newPassphrase() passphraseSource {
+	return passphraseSource{file: NewPassphraseFile, env: "SPLITFUSE_NEW_PASSPHRASE"}
+}
+
+// IsProtectedKeyfile prüft, ob die bytes ein mit einer Passphrase geschütztes Keyfile sind.
+func IsProtectedKeyfile(filebytes []byte) bool {
+	return len(filebytes) != 128 && bytes.HasPrefix(filebytes, []byte(keyfileMagic))
+}
+
+// WrapKeyBytes verschlüsselt die 128 bytes eines Keyfiles mit der Passphrase.
+func WrapKeyBytes(key []byte, passphrase []byte) ([]byte, error) {
+	if len(key) != 128 {
+		return nil, errors.New("key must be exactly 128 bytes long")
+	}
+	if len(passphrase) == 0 {
+		return nil, errors.New("passphrase is empty")
+	}
+
+	// Header mit zufälligem salt und nonce
+	header := make([]byte, keyfileHeaderSize)
+	copy(header, keyfileMagic)
+	header[8] = keyfileVersion
+	header[9] = keyfileKdfScrypt
+	header[10] = scryptLogN
+	header[11] = scryptR
+	header[12] = scryptP
+	if _, err := io.ReadFull(rand.Reader, header[13:]); err != nil {
+		return nil, err
+	}
+
+	aesgcm, err := keyfileCipher(header, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return aesgcm.Seal(header, header[45:57], key, header), nil
+}
+
+// UnwrapKeyBytes entschlüsselt ein geschütztes Keyfile und gibt die 128 bytes zurück.
+// Bei einer falschen Passphrase oder einer veränderten Datei wird ein Fehler zurück gegeben.
+func UnwrapKeyBytes(filebytes []byte, passphrase []byte) ([]byte, error) {
+	if !IsProtectedKeyfile(filebytes) || len(filebytes) != keyfileSize {
+		return nil, errors.New("not a protected key file")
+	}
+	header := filebytes[:keyfileHeaderSize]
+	if header[8] != keyfileVersion || header[9] != keyfileKdfScrypt {
+		return nil, fmt.Errorf("unsupported key file version %d (kdf %d)", header[8], header[9])
+	}
+
+	aesgcm, err := keyfileCipher(header, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	key, err := aesgcm.Open(nil, header[45:57], filebytes[keyfileHeaderSize:], header)
+	if err != nil {
+		return nil, errors.New("wrong passphrase or damaged key file")
+	}
+	return key, nil
+}
+
+// keyfileCipher leitet mit den Parametern aus dem Header den Schlüssel ab und gibt AES-256-GCM zurück.
+// Die Parameter sind noch nicht authentisiert, darum werden nur r und p der neuen Keyfiles akzeptiert und
+// logN ist begrenzt (höchstens 1 GiB Speicher). So kann ein verändertes Keyfile scrypt nicht beliebig teuer machen.
+func keyfileCipher(header []byte, passphrase []byte) (cipher.AEAD, error) {
+	logN, r, p := header[10], int(header[11]), int(header[12])
+	if logN < 10 || logN > 20 {
+		return nil, fmt.Errorf("invalid scrypt parameter logN=%d", logN)
+	}
+	if r != scryptR || p != scryptP {
+		return nil, fmt.Errorf("invalid scrypt parameters r=%d, p=%d", r, p)
+	}
+	key, err := scrypt.Key(passphrase, header[13:45], 1<<logN, r, p, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ReadPassphrase holt die Passphrase aus PassphraseFile, aus SPLITFUSE_PASSPHRASE oder vom Terminal.
+func ReadPassphrase(prompt string) ([]byte, error) {
+	return currentPassphrase().read(prompt)
+}
+
+// ReadNewPassphrase holt die Passphrase für ein neues Keyfile (newkey) wie ReadPassphrase.
+// Am Terminal muss die Passphrase zweimal eingegeben werden.
+func ReadNewPassphrase() ([]byte, error) {
+	return currentPassphrase().readNew()
+}
+
+// read holt die Passphrase aus der Datei, aus der Umgebungsvariable oder vom Terminal.
+func (s passphraseSource) read(prompt string) ([]byte, error) {
+	// Datei
+	if s.file != "" {
+		b, err := ioutil.ReadFile(s.file)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.TrimRight(b, "\r\n"), nil
+	}
+
+	// Umgebungsvariable
+	if env := os.Getenv(s.env); env != "" {
+		return []byte(env), nil
+	}
+
+	// Terminal
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, errors.New("no passphrase given and no terminal available")
+	}
+	defer tty.Close()
+	fmt.Fprint(tty, prompt)
+	passphrase, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+	return passphrase, err
+}
+
+// readNew holt eine neue Passphrase, am Terminal muss sie zweimal eingegeben werden.
+func (s passphraseSource) readNew() ([]byte, error) {
+	if s.file != "" || os.Getenv(s.env) != "" {
+		return s.read("")
+	}
+	p1, err := s.read("New passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	p2, err := s.read("Repeat passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(p1, p2) {
+		return nil, errors.New("passphrases do not match")
+	}
+	return p1, nil
+}
+
+// RewrapKeyfile schützt ein Keyfile neu mit einer Passphrase (oder entfernt mit protect == false den Schutz).
+// Die Schlüssel bleiben dabei gleich, DB und Chunks müssen also nicht neu verschlüsselt werden.
+// Die bisherige Passphrase kommt wie bei LoadKeyfile aus PassphraseFile, die neue aus NewPassphraseFile,
+// SPLITFUSE_NEW_PASSPHRASE oder vom Terminal. Die Datei wird atomar ersetzt.
+func RewrapKeyfile(path string, protect bool) error {
+	filebytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	// die 128 bytes des Keyfiles
+	key := filebytes
+	if IsProtectedKeyfile(filebytes) {
+		passphrase, err := currentPassphrase().read("Passphrase for " + path + ": ")
+		if err != nil {
+			return err
+		}
+		if key, err = UnwrapKeyBytes(filebytes, passphrase); err != nil {
+			return err
+		}
+	} else if len(key) != 128 {
+		return fmt.Errorf("key file must be exactly 128 bytes long (read %d bytes)", len(key))
+	}
+
+	// neu schützen
+	if protect {
+		passphrase, err := newPassphrase().readNew()
+		if err != nil {
+			return err
+		}
+		if key, err = WrapKeyBytes(key, passphrase); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(path, false, key)
+}
@@ -0,0 +1,227 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// So oft wird der Fortschrittsbalken am Terminal neu gezeichnet.
+const progressBarInterval = 500 * time.Millisecond
+
+// Progress zählt den Fortschritt einer langen Aufgabe (scan, push, restore) und gibt ihn regelmäßig aus:
+// am Terminal als Fortschrittsbalken, sonst als eine JSON Zeile pro Ausgabe.
+// Alle Methoden dürfen gleichzeitig und auch mit einem nil Progress aufgerufen werden.
+type Progress struct {
+	op       string
+	out      io.Writer
+	json     bool
+	interval time.Duration
+	start    time.Time
+
+	filesTotal, filesDone int64 // atomic
+	bytesTotal, bytesDone int64 // atomic
+
+	stop chan struct{}
+	done chan struct{}
+
+	parent *Progress // bei einem Versuch (siehe attempt): hier wird auch mitgezählt
+}
+
+// ProgressStatus ist ein Stand des Fortschritts (so wird er auch als JSON ausgegeben).
+type ProgressStatus struct {
+	Op          string  `json:"op"`
+	FilesDone   int64   `json:"files_done"`
+	FilesTotal  int64   `json:"files_total"`
+	BytesDone   int64   `json:"bytes_done"`
+	BytesTotal  int64   `json:"bytes_total"`
+	BytesPerSec float64 `json:"bytes_per_sec"`
+	EtaSec      float64 `json:"eta_sec"` // -1: unbekannt
+	ElapsedSec  float64 `json:"elapsed_sec"`
+}
+
+// NewProgress startet die Ausgabe nach out alle interval. Mit jsonLines wird JSON statt eines Balkens ausgegeben.
+// Am Ende muss Stop aufgerufen werden.
+func NewProgress(op string, out io.Writer, jsonLines bool, interval time.Duration) *Progress {
+	p := &Progress{
+		op:       op,
+		out:      out,
+		json:     jsonLines,
+		interval: interval,
+		start:    time.Now(),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go p.loop()
+	return p
+}
+
+// StartProgress gibt den Fortschritt nach f aus: Ist f ein Terminal, dann als Balken,
+// sonst alle interval als JSON Zeile (für Logs und andere Programme).
+func StartProgress(op string, f *os.File, interval time.Duration) *Progress {
+	if term.IsTerminal(int(f.Fd())) {
+		return NewProgress(op, f, false, progressBarInterval)
+	}
+	return NewProgress(op, f, true, interval)
+}
+
+// AddTotal erhöht die Anzahl der Dateien und Bytes, die insgesamt bearbeitet werden.
+func (p *Progress) AddTotal(files int64, bytes int64) {
+	if p == nil {
+		return
+	}
+	atomic.AddInt64(&p.filesTotal, files)
+	atomic.AddInt64(&p.bytesTotal, bytes)
+}
+
+// Add erhöht die Anzahl der fertigen Dateien und Bytes.
+func (p *Progress) Add(files int64, bytes int64) {
+	if p == nil {
+		return
+	}
+	atomic.AddInt64(&p.filesDone, files)
+	atomic.AddInt64(&p.bytesDone, bytes)
+	p.parent.Add(files, bytes)
+}
+
+// attempt gibt einen Progress für einen Versuch zurück, der auch in p mitzählt.
+// Muss der Versuch wiederholt werden, dann zieht undo seine Dateien und bytes wieder von p ab.
+func (p *Progress) attempt() *Progress {
+	if p == nil {
+		return nil
+	}
+	return &Progress{parent: p}
+}
+
+// undo zieht alles, was in diesem Versuch gezählt wurde, wieder vom Progress ab.
+func (p *Progress) undo() {
+	if p == nil {
+		return
+	}
+	p.parent.Add(-atomic.SwapInt64(&p.filesDone, 0), -atomic.SwapInt64(&p.bytesDone, 0))
+}
+
+// Stop beendet die regelmäßige Ausgabe und gibt den letzten Stand aus.
+func (p *Progress) Stop() {
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	<-p.done
+}
+
+// Status gibt den aktuellen Stand zurück. Die ETA wird aus dem bisherigen Durchsatz berechnet.
+func (p *Progress) Status() ProgressStatus {
+	s := ProgressStatus{
+		Op:         p.op,
+		FilesDone:  atomic.LoadInt64(&p.filesDone),
+		FilesTotal: atomic.LoadInt64(&p.filesTotal),
+		BytesDone:  atomic.LoadInt64(&p.bytesDone),
+		BytesTotal: atomic.LoadInt64(&p.bytesTotal),
+		EtaSec:     -1,
+	}
+	// eine Datei kann seit dem Zählen gewachsen sein (ohne Gesamtmenge wird noch gezählt)
+	if s.BytesTotal > 0 && s.BytesDone > s.BytesTotal {
+		s.BytesDone = s.BytesTotal
+	}
+	elapsed := time.Since(p.start).Seconds()
+	s.ElapsedSec = elapsed
+	if elapsed > 0 {
+		s.BytesPerSec = float64(s.BytesDone) / elapsed
+	}
+	if s.BytesTotal > 0 && s.BytesPerSec > 0 {
+		s.EtaSec = float64(s.BytesTotal-s.BytesDone) / s.BytesPerSec
+	} else if s.BytesDone == s.BytesTotal && s.FilesDone >= s.FilesTotal {
+		s.EtaSec = 0
+	}
+	return s
+}
+
+func (p *Progress) loop() {
+	defer close(p.done)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.render(false)
+		case <-p.stop:
+			p.render(true)
+			return
+		}
+	}
+}
+
+func (p *Progress) render(final bool) {
+	s := p.Status()
+	if p.json {
+		b, _ := json.Marshal(s)
+		fmt.Fprintf(p.out, "%s\n", b)
+		return
+	}
+	end := ""
+	if final {
+		end = "\n"
+	}
+	if !final && s.FilesTotal == 0 && s.BytesTotal == 0 {
+		// die Gesamtmenge ist noch nicht bekannt (z.B. scan, solange der Ordner durchsucht wird)
+		fmt.Fprintf(p.out, "\r%s", s.counting(30))
+		return
+	}
+	fmt.Fprintf(p.out, "\r%s%s", s.bar(30), end)
+}
+
+// counting gibt den Stand als Zeile zurück, solange die Gesamtmenge noch nicht bekannt ist.
+func (s ProgressStatus) counting(width int) string {
+	return fmt.Sprintf("%s [%s] counting ... %d files, %s ", s.Op, strings.Repeat("?", width), s.FilesDone, formatBytes(s.BytesDone))
+}
+
+// bar gibt den Stand als Zeile mit einem Balken der Breite width zurück.
+func (s ProgressStatus) bar(width int) string {
+	fraction := 1.0
+	if s.BytesTotal > 0 {
+		fraction = float64(s.BytesDone) / float64(s.BytesTotal)
+	} else if s.FilesTotal > 0 {
+		fraction = float64(s.FilesDone) / float64(s.FilesTotal)
+	}
+	filled := int(fraction * float64(width))
+	eta := "?"
+	if s.EtaSec >= 0 {
+		eta = (time.Duration(s.EtaSec) * time.Second).String()
+	}
+	return fmt.Sprintf("%s [%s%s] %3.0f%% %d/%d files, %s/%s, %s/s, ETA %s ",
+		s.Op, strings.Repeat("#", filled), strings.Repeat(".", width-filled), fraction*100,
+		s.FilesDone, s.FilesTotal, formatBytes(s.BytesDone), formatBytes(s.BytesTotal), formatBytes(int64(s.BytesPerSec)), eta)
+}
+
+// formatBytes gibt eine Größe lesbar aus (z.B. 1.5 GiB).
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// progressReader zählt die gelesenen bytes im Progress.
+type progressReader struct {
+	r io.Reader
+	p *Progress
+}
+
+func (r progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.p.Add(0, int64(n))
+	return n, err
+}
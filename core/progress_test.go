@@ -0,0 +1,86 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgress(t *testing.T) {
+	// nil darf überall verwendet werden
+	var nilProgress *Progress
+	nilProgress.AddTotal(1, 1)
+	nilProgress.Add(1, 1)
+	nilProgress.Stop()
+
+	// ohne Gesamtmenge (noch beim Zählen) wird kein Balken gezeigt
+	out := &bytes.Buffer{}
+	p := NewProgress("scan", out, false, time.Hour)
+	p.Add(0, 2048)
+	p.render(false)
+	if !strings.Contains(out.String(), "counting ... 0 files, 2.0 KiB") || strings.Contains(out.String(), "%") {
+		t.Errorf("wrong counting line: %q", out.String())
+	}
+	p.Stop()
+
+	out = &bytes.Buffer{}
+	p = NewProgress("scan", out, true, time.Hour)
+	p.AddTotal(4, 1000)
+	p.Add(1, 250)
+	p.start = p.start.Add(-10 * time.Second) // 25 bytes/s
+	s := p.Status()
+	if s.FilesDone != 1 || s.FilesTotal != 4 || s.BytesDone != 250 || s.BytesTotal != 1000 {
+		t.Errorf("wrong status: %+v", s)
+	}
+	if s.BytesPerSec < 24 || s.BytesPerSec > 25 || s.EtaSec < 30 || s.EtaSec > 31 {
+		t.Errorf("wrong rate or eta: %+v", s)
+	}
+	if bar := s.bar(10); !strings.HasPrefix(bar, "scan [##........]  25% 1/4 files, 250 B/1000 B") || !strings.Contains(bar, "ETA 30s") {
+		t.Errorf("wrong bar: %s", bar)
+	}
+
+	// mehr bytes als erwartet (z.B. eine Datei ist gewachsen)
+	p.Add(3, 2000)
+	p.Stop()
+	var last ProgressStatus
+	if err := json.Unmarshal(out.Bytes(), &last); err != nil {
+		t.Fatal(err)
+	}
+	if last.Op != "scan" || last.BytesDone != 1000 || last.FilesDone != 4 || last.EtaSec != 0 {
+		t.Errorf("wrong json: %s", out.String())
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, want := range map[int64]string{0: "0 B", 1023: "1023 B", 1536: "1.5 KiB", 1073741824: "1.0 GiB"} {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %s, expected %s", n, got, want)
+		}
+	}
+}
+
+func TestScanFolderProgress(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "progress.test")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "a"), make([]byte, 300000), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "b"), make([]byte, 100), 0600)
+
+	for _, jobs := range []int{1, 4} {
+		cfg := DefaultScanConfig()
+		cfg.ChunkSize = 131072
+		cfg.Jobs = jobs
+		cfg.Progress = NewProgress("scan", ioutil.Discard, true, time.Hour)
+		if _, _, _, err := ScanFolderWithConfig(dir, SfDb{}, cfg, false); err != nil {
+			t.Fatal(err)
+		}
+		cfg.Progress.Stop()
+		if s := cfg.Progress.Status(); s.FilesDone != 2 || s.FilesTotal != 2 || s.BytesDone != 300100 || s.BytesTotal != 300100 {
+			t.Errorf("jobs=%d: wrong status: %+v", jobs, s)
+		}
+	}
+}
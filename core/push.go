@@ -0,0 +1,149 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// PushChunks erzeugt die verschlüsselten Chunks direkt aus den Klartext Dateien (ohne reverse mount)
+// und schreibt alle Chunks, die im Ziel fehlen, in den target Store.
+// Ein Chunk gilt als vorhanden, wenn er im Ziel mit der richtigen Größe existiert.
+// Kann eine Datei nicht gelesen werden (z.B. weil sie seit dem Scan verändert wurde), dann werden nur ihre Chunks
+// übersprungen und am Ende ein PartialPushError mit allen betroffenen Dateien zurück gegeben.
+// Ein Fehler beim Schreiben ins Ziel bricht dagegen sofort ab.
+func PushChunks(db SfDb, k KeyFile, rootdir string, target WritableChunkStore, debug bool) (summary string, retErr error) {
+	return PushChunksWithProgress(db, k, rootdir, target, nil, debug)
+}
+
+// PushChunksWithProgress macht dasselbe wie PushChunks und zählt dabei die Chunks (als Dateien) und bytes in progress.
+func PushChunksWithProgress(db SfDb, k KeyFile, rootdir string, target WritableChunkStore, progress *Progress, debug bool) (summary string, retErr error) {
+	refs := collectChunkRefs(db, k)
+	countPushed := 0
+	var bytesPushed uint64
+	var errs []ScanError            // Dateien, die nicht gelesen werden konnten
+	failed := make(map[string]bool) // deren restliche Chunks werden übersprungen
+	for _, r := range refs {
+		progress.AddTotal(1, int64(r.size))
+	}
+
+	for _, r := range refs {
+		// Ist der Chunk im Ziel schon vorhanden?
+		if info, err := target.StatChunk(r.name); err == nil && uint64(info.Size) == r.size {
+			progress.Add(1, int64(r.size))
+			continue
+		}
+
+		// Chunk aus der ersten Datei verschlüsseln und schreiben
+		p, i := r.paths[0], r.index
+		if failed[p] {
+			progress.Add(1, int64(r.size))
+			continue
+		}
+		scanDebug(debug, fmt.Sprintf("push chunk %d of %s", i, p))
+		err := pushChunk(filepath.Join(rootdir, p), int64(r.offset), int64(r.size), r.hash, k.CalcChunkKey(r.hash[:]), r.name, target)
+		if fe, ok := err.(*pushFileError); ok {
+			// nur diese Datei überspringen
+			scanDebug(debug, fmt.Sprintf("ERROR: push chunk %d of %s: %v", i, p, fe.err))
+			failed[p] = true
+			errs = append(errs, ScanError{Path: p, Err: fmt.Errorf("chunk %d: %v", i, fe.err)})
+			progress.Add(1, int64(r.size))
+			continue
+		}
+		if err != nil {
+			retErr = fmt.Errorf("push chunk %d of %s: %v", i, p, err)
+			break
+		}
+		countPushed++
+		bytesPushed += r.size
+		progress.Add(1, int64(r.size))
+	}
+
+	if retErr == nil && len(errs) > 0 {
+		retErr = &PartialPushError{Errors: errs}
+	}
+
+	// Statistik
+	summary = fmt.Sprintf("PUSH: error=%v, chunks=%d, pushed=%d, bytes=%d, failed=%d", retErr, len(refs), countPushed, bytesPushed, len(errs))
+	return
+}
+
+// PartialPushError wird zurück gegeben, wenn einzelne Dateien nicht gelesen werden konnten.
+// Alle anderen fehlenden Chunks wurden geschrieben.
+type PartialPushError struct {
+	Errors []ScanError
+}
+
+func (e *PartialPushError) Error() string {
+	return fmt.Sprintf("%d files could not be pushed", len(e.Errors))
+}
+
+// pushFileError ist ein Fehler der Klartext Datei (nicht des Ziels), es wird nur diese Datei übersprungen.
+type pushFileError struct {
+	err error
+}
+
+func (e *pushFileError) Error() string {
+	return e.err.Error()
+}
+
+// pushChunk liest einen Teil einer Klartext Datei, verschlüsselt ihn und schreibt ihn als Chunk.
+// Dabei wird der Hash über den Klartext geprüft: Hat sich die Datei seit dem Scan geändert,
+// dann wird der Chunk NICHT geschrieben. Fehler der Klartext Datei kommen als pushFileError zurück.
+func pushChunk(path string, offset int64, size int64, h ChunkHash, chunkKey []byte, chunkName []byte, target WritableChunkStore) error {
+	fh, err := os.Open(path)
+	if err != nil {
+		return &pushFileError{err: err}
+	}
+	defer fh.Close()
+
+	plain := &hashCheckReader{r: io.NewSectionReader(fh, offset, size), hash: sha512.New(), want: h, size: size}
+	err = target.WriteChunk(chunkName, NewCryptReader(plain, 0, chunkKey))
+	if err == errHashSize || err == errHashContent {
+		return &pushFileError{err: errors.New("file changed since last scan: " + err.Error())}
+	}
+	if err != nil && plain.err != nil {
+		return &pushFileError{err: plain.err}
+	}
+	return err
+}
+
+// Fehler von hashCheckReader
+var (
+	errHashSize    = errors.New("size does not match")
+	errHashContent = errors.New("sha512 hash does not match")
+)
+
+// hashCheckReader berechnet beim Lesen den sha512 Hash und gibt am Ende statt io.EOF einen Fehler zurück,
+// wenn Hash oder Größe nicht stimmen.
+type hashCheckReader struct {
+	r    io.Reader
+	hash hash.Hash
+	want ChunkHash
+	size int64
+	read int64
+	err  error // Lesefehler der Klartext Datei
+}
+
+func (c *hashCheckReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.hash.Write(p[:n])
+	c.read += int64(n)
+	if err != nil && err != io.EOF {
+		c.err = err
+	}
+	if err == io.EOF {
+		if c.read != c.size {
+			return n, errHashSize
+		}
+		if !bytes.Equal(c.hash.Sum(nil), c.want[:]) {
+			return n, errHashContent
+		}
+	}
+	return n, err
+}
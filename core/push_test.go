@@ -0,0 +1,74 @@
+package core
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPushChunks(t *testing.T) {
+	k := LoadKeyfile(testKeyFile)
+
+	// Klartext Ordner anlegen
+	rootdir, _ := ioutil.TempDir("", "push.test")
+	defer os.RemoveAll(rootdir)
+	data := []byte("Das ist der Inhalt einer Datei, die hochgeladen werden soll.")
+	ioutil.WriteFile(filepath.Join(rootdir, "a.txt"), data, 0600)
+	ioutil.WriteFile(filepath.Join(rootdir, "b.txt"), data, 0600) // gleicher Chunk
+	ioutil.WriteFile(filepath.Join(rootdir, "leer.txt"), nil, 0600)
+
+	pushDb, _, _, err := ScanFolder(rootdir, SfDb{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Ziel anlegen
+	targetdir, _ := ioutil.TempDir("", "push.test")
+	defer os.RemoveAll(targetdir)
+	target, err := CreateDirChunkStore(targetdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// erster push: genau ein Chunk
+	summary, err := PushChunks(pushDb, k, rootdir, target, false)
+	if err != nil || !strings.Contains(summary, "chunks=1, pushed=1") {
+		t.Errorf("push #1: %s", summary)
+	}
+
+	// Chunk entschlüsseln und prüfen
+	h := pushDb["a.txt"].FileChunks[0]
+	buf, err := ioutil.ReadFile(target.ChunkPath(k.CalcChunkCryptHash(h[:])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	CryptBytes(buf, 0, k.CalcChunkKey(h[:]))
+	if !bytes.Equal(buf, data) {
+		t.Errorf("wrong chunk content: %s", buf)
+	}
+
+	// zweiter push: nichts zu tun
+	summary, err = PushChunks(pushDb, k, rootdir, target, false)
+	if err != nil || !strings.Contains(summary, "pushed=0") {
+		t.Errorf("push #2: %s", summary)
+	}
+
+	// Datei nach dem Scan verändert: Fehler und kein Chunk, die anderen Dateien werden trotzdem geschrieben
+	ioutil.WriteFile(filepath.Join(rootdir, "c.txt"), []byte("eine andere Datei"), 0600)
+	pushDb, _, _, _ = ScanFolder(rootdir, pushDb, false)
+	os.RemoveAll(targetdir)
+	target, _ = CreateDirChunkStore(targetdir)
+	ioutil.WriteFile(filepath.Join(rootdir, "a.txt"), bytes.ToUpper(data), 0600)
+	ioutil.WriteFile(filepath.Join(rootdir, "b.txt"), bytes.ToUpper(data), 0600)
+	summary, err = PushChunks(pushDb, k, rootdir, target, false)
+	partial, ok := err.(*PartialPushError)
+	if !ok || len(partial.Errors) != 1 || partial.Errors[0].Path != "a.txt" || !strings.Contains(summary, "pushed=1, bytes=17, failed=1") {
+		t.Errorf("push of changed file: %v, %s", err, summary)
+	}
+	if list, _ := target.ListChunks(); len(list) != 1 {
+		t.Errorf("only the chunk of c.txt should be written: %d", len(list))
+	}
+}
@@ -0,0 +1,196 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// RekeyChunks verschlüsselt alle Chunks der DB mit den Schlüsseln aus newK neu.
+// Jeder Chunk wird gestreamt: mit dem alten Schlüssel entschlüsselt, der Hash über den Klartext geprüft
+// und mit dem neuen Schlüssel unter dem neuen Namen abgelegt. Die alten Chunks bleiben erhalten.
+// Ein abgebrochener Lauf kann einfach wiederholt werden: Chunks, die unter dem neuen Namen mit der richtigen
+// Größe vorhanden sind, werden übersprungen.
+func RekeyChunks(db SfDb, oldK KeyFile, newK KeyFile, store WritableChunkStore, debug bool) (summary string, retErr error) {
+	refs := collectChunkRefs(db, oldK)
+	countRekeyed := 0
+	countSkipped := 0
+	var bytesRekeyed uint64
+
+	for _, r := range refs {
+		newName := newK.CalcChunkCryptHash(r.hash[:])
+
+		// schon erledigt?
+		if info, err := store.StatChunk(newName); err == nil && uint64(info.Size) == r.size {
+			countSkipped++
+			continue
+		}
+
+		scanDebug(debug, fmt.Sprintf("rekey chunk %x -> %x", r.name, newName))
+		err := rekeyChunk(store, r, oldK.CalcChunkKey(r.hash[:]), newK.CalcChunkKey(r.hash[:]), newName)
+		if err != nil {
+			retErr = fmt.Errorf("rekey chunk %d of %s: %v", r.index, r.paths[0], err)
+			break
+		}
+		countRekeyed++
+		bytesRekeyed += r.size
+	}
+
+	// Statistik
+	summary = fmt.Sprintf("REKEY: error=%v, chunks=%d, rekeyed=%d, skipped=%d, bytes=%d", retErr, len(refs), countRekeyed, countSkipped, bytesRekeyed)
+	return
+}
+
+// rekeyChunk liest einen Chunk, entschlüsselt ihn mit oldKey und schreibt ihn mit newKey unter newName.
+// Stimmt der Hash über den Klartext nicht, dann wird der neue Chunk NICHT geschrieben.
+func rekeyChunk(store WritableChunkStore, r *chunkRef, oldKey []byte, newKey []byte, newName []byte) error {
+	fh, err := store.OpenChunk(r.name)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	plain := &hashCheckReader{r: NewCryptReader(fh, 0, oldKey), hash: sha512.New(), want: r.hash, size: int64(r.size)}
+	err = store.WriteChunk(newName, NewCryptReader(plain, 0, newKey))
+	if err == errHashSize || err == errHashContent {
+		err = errors.New("old chunk is damaged: " + err.Error())
+	}
+	return err
+}
+
+// RemoveRekeyedChunks löscht die alten Chunks nach einem erfolgreichen RekeyChunks.
+// Ein alter Chunk wird nur gelöscht, wenn der neue Chunk mit der richtigen Größe vorhanden ist.
+func RemoveRekeyedChunks(db SfDb, oldK KeyFile, newK KeyFile, store WritableChunkStore, debug bool) (summary string, retErr error) {
+	refs := collectChunkRefs(db, oldK)
+	countRemoved := 0
+	var bytesRemoved uint64
+
+	for _, r := range refs {
+		newName := newK.CalcChunkCryptHash(r.hash[:])
+		if bytes.Equal(newName, r.name) {
+			continue // gleicher Schlüssel
+		}
+
+		// der neue Chunk muss vorhanden sein
+		if info, err := store.StatChunk(newName); err != nil || uint64(info.Size) != r.size {
+			retErr = fmt.Errorf("new chunk %x for chunk %d of %s is missing", newName, r.index, r.paths[0])
+			break
+		}
+
+		// alten Chunk löschen (ist er schon weg, dann ist das in Ordnung)
+		if _, err := store.StatChunk(r.name); err != nil {
+			continue
+		}
+		scanDebug(debug, fmt.Sprintf("remove old chunk %x", r.name))
+		if err := store.RemoveChunk(r.name); err != nil {
+			retErr = err
+			break
+		}
+		countRemoved++
+		bytesRemoved += r.size
+	}
+
+	// Statistik
+	summary = fmt.Sprintf("REKEY CLEANUP: error=%v, chunks=%d, removed=%d, bytes=%d", retErr, len(refs), countRemoved, bytesRemoved)
+	return
+}
+
+// rekeyFile ist eine DB Datei (die DB oder eine Generation), die neu verschlüsselt wird.
+type rekeyFile struct {
+	path string
+	db   SfDb
+	hdr  DbHeader
+	done bool // schon mit dem neuen Schlüssel verschlüsselt (abgebrochener Lauf)
+}
+
+// readRekeyFile liest eine DB Datei mit dem neuen oder (noch nicht umgestellt) mit dem alten Schlüssel.
+func readRekeyFile(path string, oldK KeyFile, newK KeyFile) (rekeyFile, error) {
+	db, hdr, err := ReadDbFile(path, newK.DbKey())
+	if err == nil {
+		return rekeyFile{path: path, db: db, hdr: hdr, done: true}, nil
+	}
+	db, hdr, err = ReadDbFile(path, oldK.DbKey())
+	if err != nil {
+		return rekeyFile{}, fmt.Errorf("%s: %v", path, err)
+	}
+	return rekeyFile{path: path, db: db, hdr: hdr}, nil
+}
+
+// RekeyDbFiles stellt die DB dbpath und alle ihre Generationen auf das Keyfile newK um.
+// Zuerst werden die Chunks aller Stände neu verschlüsselt (RekeyChunks), danach werden die Generationen
+// und zuletzt die DB selbst mit dem neuen Schlüssel geschrieben. Der Kopf (z.B. die Chunkgröße) bleibt erhalten.
+// <dbpath>.bak und der Checkpoint sind noch mit dem alten Schlüssel verschlüsselt und werden gelöscht.
+// Mit remove werden am Ende die alten Chunks gelöscht (RemoveRekeyedChunks).
+// Ein abgebrochener Lauf kann wiederholt werden: Dateien mit dem neuen Schlüssel werden nicht mehr verändert.
+func RekeyDbFiles(dbpath string, oldK KeyFile, newK KeyFile, store WritableChunkStore, remove bool, debug bool) (summary string, retErr error) {
+	var lines []string // eine Zeile Statistik je Schritt
+
+	// alle Stände lesen (die DB zuletzt, sie wird auch als letztes umgestellt)
+	gens, err := ListGenerations(dbpath)
+	if err != nil {
+		return strings.Join(lines, "\n"), err
+	}
+	var files []rekeyFile
+	for _, path := range append(generationPaths(gens), dbpath) {
+		f, err := readRekeyFile(path, oldK, newK)
+		if err != nil {
+			return strings.Join(lines, "\n"), err
+		}
+		files = append(files, f)
+	}
+
+	// Chunks aller Stände neu verschlüsseln (gemeinsame Chunks werden beim zweiten Mal übersprungen)
+	for _, f := range files {
+		s, err := RekeyChunks(f.db, oldK, newK, store, debug)
+		lines = append(lines, f.path+": "+s)
+		if err != nil {
+			return strings.Join(lines, "\n"), err
+		}
+	}
+
+	// DB Dateien umstellen (der Inhalt wird immer im aktuellen Format geschrieben)
+	countWritten := 0
+	for _, f := range files {
+		if f.done {
+			continue
+		}
+		scanDebug(debug, "rekey db "+f.path)
+		f.hdr.Version = DbVersion
+		if err := WriteDbFile(f.path, newK.DbKey(), f.hdr, f.db, false); err != nil {
+			return strings.Join(lines, "\n"), err
+		}
+		countWritten++
+	}
+
+	// Reste mit dem alten Schlüssel löschen
+	for _, path := range []string{dbpath + ".bak", CheckpointPath(dbpath)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return strings.Join(lines, "\n"), err
+		}
+	}
+	lines = append(lines, fmt.Sprintf("REKEY DB: dbs=%d, rewritten=%d", len(files), countWritten))
+
+	// alte Chunks löschen (erst, wenn alle Stände umgestellt sind)
+	if remove {
+		for _, f := range files {
+			s, err := RemoveRekeyedChunks(f.db, oldK, newK, store, debug)
+			lines = append(lines, f.path+": "+s)
+			if err != nil {
+				return strings.Join(lines, "\n"), err
+			}
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// generationPaths gibt die Pfade der Generationen zurück.
+func generationPaths(gens []Generation) []string {
+	paths := make([]string, 0, len(gens)+1)
+	for _, g := range gens {
+		paths = append(paths, g.Path)
+	}
+	return paths
+}
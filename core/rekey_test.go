@@ -0,0 +1,169 @@
+package core
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRekeyChunks(t *testing.T) {
+	oldK := LoadKeyfile(testKeyFile)
+	newK := keyFromBytes(bytes.Repeat([]byte{0x42}, 128))
+
+	// Klartext Ordner anlegen und die Chunks mit dem alten Schlüssel schreiben
+	rootdir, _ := ioutil.TempDir("", "rekey.test")
+	defer os.RemoveAll(rootdir)
+	data := []byte("Diese Datei bekommt einen neuen Schluessel.")
+	ioutil.WriteFile(filepath.Join(rootdir, "a.txt"), data, 0600)
+	ioutil.WriteFile(filepath.Join(rootdir, "b.txt"), []byte("noch eine Datei"), 0600)
+	db, _, _, err := ScanFolder(rootdir, SfDb{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storedir := newTestChunkDir(t)
+	defer os.RemoveAll(storedir)
+	store, _ := NewDirChunkStore(storedir)
+	if _, err := PushChunks(db, oldK, rootdir, store, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// neu verschlüsseln
+	summary, err := RekeyChunks(db, oldK, newK, store, false)
+	if err != nil || !strings.Contains(summary, "chunks=2, rekeyed=2, skipped=0") {
+		t.Errorf("rekey #1: %s", summary)
+	}
+
+	// neuer Chunk ist mit dem neuen Schlüssel lesbar
+	h := db["a.txt"].FileChunks[0]
+	buf, err := ioutil.ReadFile(store.ChunkPath(newK.CalcChunkCryptHash(h[:])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	CryptBytes(buf, 0, newK.CalcChunkKey(h[:]))
+	if !bytes.Equal(buf, data) {
+		t.Errorf("wrong chunk content: %s", buf)
+	}
+
+	// wiederholen (z.B. nach Abbruch): nichts zu tun
+	summary, err = RekeyChunks(db, oldK, newK, store, false)
+	if err != nil || !strings.Contains(summary, "rekeyed=0, skipped=2") {
+		t.Errorf("rekey #2: %s", summary)
+	}
+
+	// alte Chunks löschen
+	summary, err = RemoveRekeyedChunks(db, oldK, newK, store, false)
+	if err != nil || !strings.Contains(summary, "removed=2") {
+		t.Errorf("cleanup: %s", summary)
+	}
+	if list, _ := store.ListChunks(); len(list) != 2 {
+		t.Errorf("only the new chunks should be left: %d", len(list))
+	}
+	if _, err := store.StatChunk(oldK.CalcChunkCryptHash(h[:])); err == nil {
+		t.Errorf("old chunk should be removed")
+	}
+}
+
+func TestRekeyDamagedChunk(t *testing.T) {
+	oldK := LoadKeyfile(testKeyFile)
+	newK := keyFromBytes(bytes.Repeat([]byte{0x42}, 128))
+
+	rootdir, _ := ioutil.TempDir("", "rekey.test")
+	defer os.RemoveAll(rootdir)
+	ioutil.WriteFile(filepath.Join(rootdir, "a.txt"), []byte("kaputter chunk"), 0600)
+	db, _, _, _ := ScanFolder(rootdir, SfDb{}, false)
+	storedir := newTestChunkDir(t)
+	defer os.RemoveAll(storedir)
+	store, _ := NewDirChunkStore(storedir)
+	PushChunks(db, oldK, rootdir, store, false)
+
+	// alten Chunk beschädigen
+	h := db["a.txt"].FileChunks[0]
+	oldPath := store.ChunkPath(oldK.CalcChunkCryptHash(h[:]))
+	buf, _ := ioutil.ReadFile(oldPath)
+	buf[0] ^= 0xff
+	ioutil.WriteFile(oldPath, buf, 0600)
+
+	// Fehler und kein neuer Chunk; die alten Chunks dürfen nicht gelöscht werden
+	if _, err := RekeyChunks(db, oldK, newK, store, false); err == nil {
+		t.Errorf("rekey of damaged chunk should fail")
+	}
+	if list, _ := store.ListChunks(); len(list) != 1 {
+		t.Errorf("no new chunk should be written: %d", len(list))
+	}
+	if _, err := RemoveRekeyedChunks(db, oldK, newK, store, false); err == nil {
+		t.Errorf("cleanup without new chunks should fail")
+	}
+}
+
+func TestRekeyDbFiles(t *testing.T) {
+	oldK := LoadKeyfile(testKeyFile)
+	newK := keyFromBytes(bytes.Repeat([]byte{0x42}, 128))
+
+	// DB mit eigener Chunkgröße, einer älteren Generation, .bak und Checkpoint
+	rootdir, _ := ioutil.TempDir("", "rekey.test")
+	defer os.RemoveAll(rootdir)
+	ioutil.WriteFile(filepath.Join(rootdir, "a.txt"), []byte("alter stand"), 0600)
+	cfg := DefaultScanConfig()
+	cfg.ChunkSize = 131072
+	storedir := newTestChunkDir(t)
+	defer os.RemoveAll(storedir)
+	store, _ := NewDirChunkStore(storedir)
+	genDB, _, _, _ := ScanFolderWithConfig(rootdir, SfDb{}, cfg, false)
+	PushChunks(genDB, oldK, rootdir, store, false)
+	ioutil.WriteFile(filepath.Join(rootdir, "a.txt"), []byte("neuer stand der datei"), 0600)
+	db, _, _, _ := ScanFolderWithConfig(rootdir, SfDb{}, cfg, false)
+	PushChunks(db, oldK, rootdir, store, false)
+
+	dbdir, _ := ioutil.TempDir("", "rekey.test")
+	defer os.RemoveAll(dbdir)
+	dbpath := filepath.Join(dbdir, "index.db")
+	hdr := NewDbHeader()
+	hdr.ChunkSize = cfg.ChunkSize
+	SaveGeneration(dbpath, oldK.DbKey(), hdr, genDB, time.Unix(1000, 0))
+	WriteDbFile(dbpath, oldK.DbKey(), hdr, db, false)
+	WriteDbFile(dbpath, oldK.DbKey(), hdr, db, true)
+	WriteCheckpoint(CheckpointPath(dbpath), oldK.DbKey(), Checkpoint{Root: rootdir})
+
+	summary, err := RekeyDbFiles(dbpath, oldK, newK, store, true, false)
+	if err != nil || !strings.Contains(summary, "dbs=2, rewritten=2") {
+		t.Fatalf("rekey: %v\n%s", err, summary)
+	}
+
+	// DB und Generation sind mit dem neuen Schlüssel lesbar, die Chunkgröße bleibt
+	if _, h, err := ReadDbFile(dbpath, newK.DbKey()); err != nil || h.ChunkSize != cfg.ChunkSize {
+		t.Errorf("db: %v, chunk size %d", err, h.ChunkSize)
+	}
+	gens, _ := ListGenerations(dbpath)
+	if len(gens) != 1 {
+		t.Fatalf("generations: %v", gens)
+	}
+	if _, err := DbFromFile(gens[0].Path, newK.DbKey()); err != nil {
+		t.Errorf("generation: %v", err)
+	}
+	for _, path := range []string{dbpath + ".bak", CheckpointPath(dbpath)} {
+		if _, err := os.Stat(path); err == nil {
+			t.Errorf("%s should be removed", path)
+		}
+	}
+
+	// nur noch die Chunks mit den neuen Namen (auch die der Generation)
+	for _, d := range []SfDb{db, genDB} {
+		h := d["a.txt"].FileChunks[0]
+		if _, err := store.StatChunk(newK.CalcChunkCryptHash(h[:])); err != nil {
+			t.Errorf("new chunk missing: %v", err)
+		}
+		if _, err := store.StatChunk(oldK.CalcChunkCryptHash(h[:])); err == nil {
+			t.Errorf("old chunk should be removed")
+		}
+	}
+
+	// wiederholen: nichts mehr zu tun
+	summary, err = RekeyDbFiles(dbpath, oldK, newK, store, true, false)
+	if err != nil || !strings.Contains(summary, "rewritten=0") {
+		t.Errorf("rekey again: %v\n%s", err, summary)
+	}
+}
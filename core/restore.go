@@ -0,0 +1,276 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RestoreFiles stellt die Klartext Dateien ohne FUSE aus den Chunks wieder her.
+// Mit subpath kann ein Unterordner oder eine einzelne Datei gewählt werden ("" oder "." für alles).
+// Die Elemente werden mit ihrem relativen Pfad unter target angelegt, die mtime und die Rechte werden übernommen.
+// Als root werden auch Besitzer und Gruppe wiederhergestellt.
+// Dateien, die in target schon mit gleicher Größe und mtime existieren, werden übersprungen. Ihr Inhalt wird
+// dabei NICHT geprüft (wie bei rsync ohne --checksum), eine so veränderte Datei muss vorher gelöscht werden.
+// Jeder geschriebene Chunk wird gegen seinen ChunkHash geprüft.
+// Ein vorhandener Ordner wird nur ersetzt, wenn er leer ist, und Links im Ziel werden nie verfolgt.
+func RestoreFiles(db SfDb, k KeyFile, store ChunkStore, target string, subpath string, debug bool) (summary string, retErr error) {
+	return RestoreFilesWithProgress(db, k, store, target, subpath, nil, debug)
+}
+
+// RestoreFilesWithProgress macht dasselbe wie RestoreFiles und zählt dabei die Dateien und bytes in p.
+func RestoreFilesWithProgress(db SfDb, k KeyFile, store ChunkStore, target string, subpath string, p *Progress, debug bool) (summary string, retErr error) {
+	r := &restorer{db: db, k: k, store: store, target: target, progress: p, chown: os.Geteuid() == 0, debug: debug}
+
+	// Startpunkt suchen
+	subpath = filepath.Clean(subpath)
+	if _, ok := db[subpath]; !ok {
+		retErr = errors.New("path not found in db: " + subpath)
+	} else {
+		// Ordner über dem Startpunkt anlegen
+		retErr = mkdirNoFollow(target, filepath.Dir(subpath))
+		if retErr == nil {
+			r.countTotal(subpath)
+			retErr = r.restore(subpath)
+		}
+	}
+
+	// Statistik
+	summary = fmt.Sprintf("RESTORE: error=%v, folders=%d, files=%d, skipped=%d, bytes=%d", retErr, r.countFolders, r.countFiles, r.countSkipped, r.bytesWritten)
+	return
+}
+
+// restorer enthält alles, was beim Wiederherstellen gebraucht wird
+type restorer struct {
+	db     SfDb
+	k      KeyFile
+	store  ChunkStore
+	target string
+	debug  bool
+
+	progress *Progress
+	chown    bool // Besitzer und Gruppe setzen (nur als root möglich)
+
+	countFolders int
+	countFiles   int
+	countSkipped int
+	bytesWritten uint64
+}
+
+// restore stellt ein Element (und bei Ordnern rekursiv den ganzen Inhalt) wieder her.
+func (r *restorer) restore(relpath string) error {
+	e, ok := r.db[relpath]
+	if !ok {
+		return errors.New("path not found in db: " + relpath)
+	}
+	path := filepath.Join(r.target, relpath)
+
+	// Link
+	if e.IsLink {
+		if target, err := os.Readlink(path); err == nil && target == e.LinkTarget {
+			r.countSkipped++
+			r.progress.Add(1, 0)
+			return nil
+		}
+		scanDebug(r.debug, "restore link: "+relpath)
+		if err := r.restoreLink(path, e); err != nil {
+			return fmt.Errorf("%s: %v", relpath, err)
+		}
+		r.countFiles++
+		r.progress.Add(1, 0)
+		return nil
+	}
+
+	// Datei
+	if e.IsFile {
+		if info, err := os.Lstat(path); err == nil && info.Mode().IsRegular() && uint64(info.Size()) == e.Size && info.ModTime().Equal(e.MtimeTime()) {
+			r.countSkipped++
+			r.progress.Add(1, int64(e.Size))
+			return r.restoreMeta(path, e)
+		}
+		scanDebug(r.debug, "restore file: "+relpath)
+		if err := r.restoreFile(path, e); err != nil {
+			return fmt.Errorf("%s: %v", relpath, err)
+		}
+		r.countFiles++
+		r.progress.Add(1, 0)
+		return r.restoreMeta(path, e)
+	}
+
+	// Ordner (ein Link oder eine Datei an dieser Stelle wird ersetzt, sonst landet der Inhalt woanders)
+	scanDebug(r.debug, "restore folder: "+relpath)
+	if info, err := os.Lstat(path); err == nil && !info.IsDir() {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	if e.HasMeta {
+		// bis der Inhalt fertig ist, muss der Ordner beschreibbar sein
+		if err := os.Chmod(path, e.FileMode()|0700); err != nil {
+			return err
+		}
+	}
+	for _, sub := range e.FolderContent {
+		if err := r.restore(filepath.Join(relpath, sub.Name)); err != nil {
+			return err
+		}
+	}
+	r.countFolders++
+
+	// die mtime und die Rechte erst setzen, wenn der Inhalt fertig ist
+	return r.restoreMeta(path, e)
+}
+
+// restoreMeta setzt Besitzer, Rechte (nur mit Metadaten) und die mtime.
+// chown kommt vor chmod, weil chown die setuid und setgid Bits löscht.
+func (r *restorer) restoreMeta(path string, e SfFile) error {
+	if e.HasMeta {
+		if r.chown {
+			if err := os.Lchown(path, int(e.Uid), int(e.Gid)); err != nil {
+				return err
+			}
+		}
+		if err := os.Chmod(path, e.FileMode()); err != nil {
+			return err
+		}
+	}
+	mtime := e.MtimeTime()
+	return os.Chtimes(path, mtime, mtime)
+}
+
+// restoreLink legt einen Link neu an (eine vorhandene Datei wird ersetzt).
+// chmod und die mtime folgen dem Link und werden deshalb nicht gesetzt, als root wird nur der Besitzer übernommen.
+func (r *restorer) restoreLink(path string, e SfFile) error {
+	if info, err := os.Lstat(path); err == nil && !info.IsDir() {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	if err := os.Symlink(e.LinkTarget, path); err != nil {
+		return err
+	}
+	if e.HasMeta && r.chown {
+		return os.Lchown(path, int(e.Uid), int(e.Gid))
+	}
+	return nil
+}
+
+// countTotal zählt die Dateien und bytes unter relpath für den Fortschritt.
+func (r *restorer) countTotal(relpath string) {
+	if r.progress == nil {
+		return
+	}
+	e := r.db[relpath]
+	if e.IsLink {
+		r.progress.AddTotal(1, 0)
+		return
+	}
+	if e.IsFile {
+		r.progress.AddTotal(1, int64(e.Size))
+		return
+	}
+	for _, sub := range e.FolderContent {
+		r.countTotal(filepath.Join(relpath, sub.Name))
+	}
+}
+
+// mkdirNoFollow legt die Ordner relpath unter target an (wie os.MkdirAll).
+// Ist einer davon ein Link, dann wird abgebrochen, damit nicht außerhalb von target geschrieben wird.
+func mkdirNoFollow(target string, relpath string) error {
+	path := target
+	for _, name := range strings.Split(relpath, string(filepath.Separator)) {
+		if name == "." || name == "" {
+			continue
+		}
+		path = filepath.Join(path, name)
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			err = os.Mkdir(path, 0755)
+		} else if err == nil && !info.IsDir() {
+			err = errors.New("not a folder (links are not followed): " + path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreFile schreibt eine Datei aus ihren Chunks. Im Fehlerfall wird die halbe Datei wieder gelöscht.
+// Alles, was keine normale Datei ist (z.B. ein Link aus einem früheren Lauf), wird vorher gelöscht und
+// Links werden beim Öffnen nicht verfolgt: es wird nie außerhalb von target geschrieben.
+// Ein Ordner an dieser Stelle wird nur gelöscht, wenn er leer ist, sonst gibt es einen Fehler.
+func (r *restorer) restoreFile(path string, e SfFile) error {
+	if info, err := os.Lstat(path); err == nil {
+		if info.IsDir() {
+			if err = os.Remove(path); err != nil {
+				err = fmt.Errorf("can't replace folder with a file: %v", err)
+			}
+		} else if !info.Mode().IsRegular() {
+			err = os.Remove(path)
+		} else if info.Mode().Perm()&0200 == 0 {
+			// z.B. 0444 aus einem früheren Lauf (die Rechte werden danach wieder gesetzt)
+			err = os.Chmod(path, info.Mode().Perm()|0200)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	fh, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|openNoFollow, 0666)
+	if err != nil {
+		return err
+	}
+
+	for i, h := range e.FileChunks {
+		_, size := e.ChunkBounds(i)
+		if size < 1 {
+			continue
+		}
+		if err = r.restoreChunk(fh, h, size); err != nil {
+			err = fmt.Errorf("chunk %d: %v", i, err)
+			break
+		}
+		r.bytesWritten += size
+		r.progress.Add(0, int64(size))
+	}
+
+	if e := fh.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// restoreChunk entschlüsselt einen Chunk, prüft den Hash und hängt ihn an w an.
+func (r *restorer) restoreChunk(w io.Writer, h ChunkHash, size uint64) error {
+	ch, err := r.store.OpenChunk(r.k.CalcChunkCryptHash(h[:]))
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	hf := sha512.New()
+	plain := NewCryptReader(io.LimitReader(ch, int64(size)), 0, r.k.CalcChunkKey(h[:]))
+	n, err := io.Copy(io.MultiWriter(w, hf), plain)
+	if err != nil {
+		return err
+	}
+	if uint64(n) != size {
+		return &ChunkCorruptError{Reason: fmt.Sprintf("size is %d, expected %d", n, size)}
+	}
+	if !bytes.Equal(hf.Sum(nil), h[:]) {
+		return &ChunkCorruptError{Reason: "hash mismatch"}
+	}
+	return nil
+}
@@ -0,0 +1,7 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package core
+
+// Windows und andere Systeme ohne O_NOFOLLOW: vorhandene Links werden vor dem Öffnen gelöscht (restoreFile).
+const openNoFollow = 0
@@ -0,0 +1,95 @@
+package core
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestoreFiles(t *testing.T) {
+	k := LoadKeyfile(testKeyFile)
+
+	// Klartext Ordner mit Unterordner
+	rootdir, _ := ioutil.TempDir("", "restore.test")
+	defer os.RemoveAll(rootdir)
+	os.MkdirAll(filepath.Join(rootdir, "sub", "leer"), 0755)
+	ioutil.WriteFile(filepath.Join(rootdir, "a.txt"), []byte("datei a"), 0600)
+	ioutil.WriteFile(filepath.Join(rootdir, "sub", "b.txt"), []byte("datei b"), 0600)
+	ioutil.WriteFile(filepath.Join(rootdir, "sub", "null.txt"), nil, 0600)
+	mtime := time.Unix(1500000000, 987654321)
+	os.Chmod(filepath.Join(rootdir, "sub", "b.txt"), 0751)
+	os.Chmod(filepath.Join(rootdir, "sub"), 0550)
+	defer os.Chmod(filepath.Join(rootdir, "sub"), 0755)
+	os.Chtimes(filepath.Join(rootdir, "sub", "b.txt"), mtime, mtime)
+	os.Chtimes(filepath.Join(rootdir, "sub"), mtime, mtime)
+	rdb, _, _, _ := ScanFolder(rootdir, SfDb{}, false)
+
+	dir := newTestChunkDir(t)
+	defer os.RemoveAll(dir)
+	store, _ := NewDirChunkStore(dir)
+	PushChunks(rdb, k, rootdir, store, false)
+
+	// alles wiederherstellen
+	target, _ := ioutil.TempDir("", "restore.test")
+	defer os.RemoveAll(target)
+	summary, err := RestoreFiles(rdb, k, store, target, "", false)
+	if err != nil || !strings.Contains(summary, "folders=3, files=3") {
+		t.Errorf("restore: %s", summary)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(target, "sub", "b.txt")); !bytes.Equal(data, []byte("datei b")) {
+		t.Errorf("wrong content: %s", data)
+	}
+	for _, p := range []string{filepath.Join("sub", "b.txt"), "sub"} {
+		if info, err := os.Stat(filepath.Join(target, p)); err != nil || !info.ModTime().Equal(mtime) {
+			t.Errorf("wrong mtime: %s", p)
+		}
+	}
+	if info, _ := os.Stat(filepath.Join(target, "sub", "b.txt")); info.Mode().Perm() != 0751 {
+		t.Errorf("wrong mode: %v", info.Mode())
+	}
+	if info, _ := os.Stat(filepath.Join(target, "sub")); info.Mode().Perm() != 0550 {
+		t.Errorf("wrong folder mode: %v", info.Mode())
+	}
+	defer os.Chmod(filepath.Join(target, "sub"), 0755)
+
+	// nochmal: alles wird übersprungen
+	summary, err = RestoreFiles(rdb, k, store, target, ".", false)
+	if err != nil || !strings.Contains(summary, "files=0, skipped=3") {
+		t.Errorf("restore again: %s", summary)
+	}
+
+	// nur eine Datei aus einem Unterordner
+	target2, _ := ioutil.TempDir("", "restore.test")
+	defer os.RemoveAll(target2)
+	if _, err := RestoreFiles(rdb, k, store, target2, "sub/b.txt", false); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(filepath.Join(target2, "sub", "b.txt")); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(filepath.Join(target2, "a.txt")); err == nil {
+		t.Errorf("a.txt should not be restored")
+	}
+
+	// unbekannter Pfad
+	if _, err := RestoreFiles(rdb, k, store, target2, "gibtsnicht", false); err == nil {
+		t.Errorf("restore of unknown path should fail")
+	}
+
+	// beschädigter Chunk: Fehler und keine halbe Datei
+	h := rdb["a.txt"].FileChunks[0]
+	path := store.ChunkPath(k.CalcChunkCryptHash(h[:]))
+	data, _ := ioutil.ReadFile(path)
+	data[0] ^= 0xff
+	ioutil.WriteFile(path, data, 0600)
+	if _, err := RestoreFiles(rdb, k, store, target2, "a.txt", false); err == nil {
+		t.Errorf("restore of corrupt chunk should fail")
+	}
+	if _, err := os.Stat(filepath.Join(target2, "a.txt")); err == nil {
+		t.Errorf("corrupt a.txt should be removed")
+	}
+}
@@ -0,0 +1,9 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package core
+
+import "syscall"
+
+// openNoFollow verhindert beim Wiederherstellen, dass ein Link an Stelle einer Datei verfolgt wird.
+const openNoFollow = syscall.O_NOFOLLOW
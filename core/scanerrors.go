@@ -0,0 +1,111 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ScanError ist ein Fehler bei einem einzelnen Element (relativer Pfad) im tolerant Modus.
+type ScanError struct {
+	Path string
+	Err  error
+}
+
+func (e ScanError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+// PartialScanError wird im tolerant Modus zurück gegeben, wenn einzelne Elemente nicht gescannt werden konnten.
+// Die DB ist trotzdem vollständig: Für diese Elemente wurde der alte Eintrag übernommen oder sie fehlen.
+type PartialScanError struct {
+	Errors []ScanError
+}
+
+func (e *PartialScanError) Error() string {
+	return fmt.Sprintf("%d paths could not be scanned", len(e.Errors))
+}
+
+// scanErrors sammelt die Fehler im tolerant Modus (jeder Pfad nur einmal).
+type scanErrors struct {
+	tolerant bool
+	errs     []ScanError
+	seen     map[string]bool
+}
+
+// add merkt sich den Fehler und gibt nil zurück, damit der Scan weiter läuft.
+// Ist der tolerant Modus aus, dann wird err zurück gegeben (und der Scan abgebrochen).
+// Fehler beim Root-Ordner brechen den Scan immer ab.
+func (s *scanErrors) add(relPath string, err error) error {
+	if !s.tolerant || relPath == "." {
+		return err
+	}
+	if s.seen == nil {
+		s.seen = make(map[string]bool)
+	}
+	if !s.seen[relPath] {
+		s.seen[relPath] = true
+		s.errs = append(s.errs, ScanError{Path: relPath, Err: err})
+	}
+	return nil
+}
+
+// err gibt den PartialScanError zurück (oder nil, wenn es keine Fehler gab).
+func (s *scanErrors) err() error {
+	if len(s.errs) == 0 {
+		return nil
+	}
+	return &PartialScanError{Errors: s.errs}
+}
+
+// keepOld übernimmt den alten Eintrag eines Elements, das nicht gescannt werden konnte.
+// Bei einem Ordner werden auch alle alten Einträge darunter übernommen.
+func keepOld(oldDB SfDb, newDB SfDb, relPath string) {
+	e, ok := oldDB[relPath]
+	if !ok {
+		return
+	}
+	newDB[relPath] = e
+	delete(oldDB, relPath)
+	if e.IsFile || e.IsLink {
+		return
+	}
+	prefix := relPath + string(os.PathSeparator)
+	for k, v := range oldDB {
+		if strings.HasPrefix(k, prefix) {
+			newDB[k] = v
+			delete(oldDB, k)
+		}
+	}
+}
+
+// fixFolderContent passt den Ordnerinhalt der Elternordner an die Elemente mit Fehlern an:
+// Ein übernommenes Element muss im Ordner stehen, ein fehlendes Element darf es nicht.
+func fixFolderContent(newDB SfDb, errs []ScanError) {
+	for _, se := range errs {
+		parentPath, name := filepath.Split(se.Path)
+		parentPath = filepath.Clean(parentPath)
+		parent, ok := newDB[parentPath]
+		if !ok {
+			continue // der Elternordner wurde selbst übernommen oder fehlt
+		}
+		e, exists := newDB[se.Path]
+
+		content := make([]FolderContent, 0, len(parent.FolderContent)+1)
+		for _, c := range parent.FolderContent {
+			if c.Name != name {
+				content = append(content, c)
+			}
+		}
+		if exists {
+			content = append(content, FolderContent{Name: name, IsFile: e.IsFile, IsLink: e.IsLink})
+			sort.Slice(content, func(a, b int) bool {
+				return content[a].Name < content[b].Name
+			})
+		}
+		parent.FolderContent = content
+		newDB[parentPath] = parent
+	}
+}
@@ -0,0 +1,98 @@
+package core
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// unreadableFile legt einen Unix Socket an: Er ist kein Ordner, kann aber (auch als root) nicht geöffnet werden.
+func unreadableFile(t *testing.T, path string) net.Listener {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestScanFolderTolerant(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "tolerant.test")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "b.txt"), []byte("b"), 0600)
+
+	cfg := DefaultScanConfig()
+	db, _, _, err := ScanFolderWithConfig(dir, SfDb{}, cfg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := db["b.txt"]
+
+	// b.txt und new.txt können nicht mehr gelesen werden
+	os.Remove(filepath.Join(dir, "b.txt"))
+	defer unreadableFile(t, filepath.Join(dir, "b.txt")).Close()
+	defer unreadableFile(t, filepath.Join(dir, "new.txt")).Close()
+	ioutil.WriteFile(filepath.Join(dir, "c.txt"), []byte("c"), 0600)
+
+	// ohne tolerant Modus wird abgebrochen
+	if _, _, _, err := ScanFolderWithConfig(dir, db, cfg, false); err == nil {
+		t.Fatal("scan should fail")
+	} else if _, ok := err.(*PartialScanError); ok {
+		t.Fatal("scan should fail completely")
+	}
+
+	cfg.Tolerant = true
+	cfg.Jobs = 2
+	newDB, changed, _, err := ScanFolderWithConfig(dir, db, cfg, false)
+	partial, ok := err.(*PartialScanError)
+	if !ok || len(partial.Errors) != 2 || partial.Errors[0].Path != "b.txt" || partial.Errors[1].Path != "new.txt" {
+		t.Fatalf("wrong error: %v", err)
+	}
+	if !changed {
+		t.Error("c.txt is new")
+	}
+	if _, ok := newDB["c.txt"]; !ok {
+		t.Error("c.txt is missing")
+	}
+	if e, ok := newDB["b.txt"]; !ok || e.Mtime != old.Mtime || len(e.FileChunks) != 1 {
+		t.Error("old entry of b.txt should be kept")
+	}
+	if _, ok := newDB["new.txt"]; ok {
+		t.Error("new.txt should be skipped")
+	}
+	var names []string
+	for _, c := range newDB["."].FolderContent {
+		names = append(names, c.Name)
+	}
+	if len(names) != 3 || names[0] != "a.txt" || names[1] != "b.txt" || names[2] != "c.txt" {
+		t.Errorf("wrong folder content: %v", names)
+	}
+
+	// Fehler beim Root-Ordner brechen immer ab
+	if _, _, _, err := ScanFolderWithConfig(filepath.Join(dir, "missing"), db, cfg, false); err == nil {
+		t.Error("missing root should fail")
+	} else if _, ok := err.(*PartialScanError); ok {
+		t.Error("missing root should fail completely")
+	}
+}
+
+func TestKeepOldFolder(t *testing.T) {
+	sep := string(os.PathSeparator)
+	oldDB := SfDb{
+		".":               SfFile{FolderContent: []FolderContent{{Name: "dir"}}},
+		"dir":             SfFile{FolderContent: []FolderContent{{Name: "f", IsFile: true}}},
+		"dir" + sep + "f": SfFile{IsFile: true, Size: 1},
+		"dirty":           SfFile{IsFile: true},
+	}
+	newDB := SfDb{".": SfFile{}}
+	keepOld(oldDB, newDB, "dir")
+	if len(newDB) != 3 || len(oldDB) != 2 {
+		t.Errorf("wrong entries: new=%d, old=%d", len(newDB), len(oldDB))
+	}
+	fixFolderContent(newDB, []ScanError{{Path: "dir"}, {Path: "gone"}})
+	if c := newDB["."].FolderContent; len(c) != 1 || c[0].Name != "dir" || c[0].IsFile {
+		t.Errorf("wrong folder content: %v", c)
+	}
+}
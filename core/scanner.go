@@ -12,15 +12,76 @@ import (
 const (
 	// Die CHUNK_SIZE sollte ein vielfaches der FUSE-Puffers 131072 Byte (128 Kibibyte)
 	// und einer Blockgröße der Festpltten (zB 4096 Byte)
+	// Das ist die Standardgröße, eine DB kann eine andere Größe verwenden (ScanConfig.ChunkSize).
 	CHUNKSIZE = 131072 * 4096 * 2 // 1073741824 Byte (1024 Mebibyte)
 
 	// Der READ_BUFFER_SIZE sollte sich zwischen 10 MB und 20 MB bewegen
-	// und muss die CHUNKSIZE Ganzzahlig teilen können!
 	BUFFERSIZE = 16777216 // 16777216 Byte (16 Mebibyte)
 )
 
-// gibt den Ordnerinhalt zurück
-func readDirNames(dirname string) ([]FolderContent, error) {
+// Verfahren zum Aufteilen der Dateien in Chunks
+const (
+	ChunkingFixed = "fixed" // feste Größe (CHUNKSIZE)
+	ChunkingCDC   = "cdc"   // content-defined chunking (die Grenzen werden in SfFile.ChunkOffsets gespeichert)
+)
+
+// ScanConfig enthält die Einstellungen für den Scan.
+type ScanConfig struct {
+	Jobs      int      // so viele Chunks werden gleichzeitig gehasht (0 oder 1: nacheinander)
+	Chunking  string   // ChunkingFixed oder ChunkingCDC
+	ChunkSize uint64   // fixed: Größe der Chunks (siehe CheckChunkSize)
+	MinSize   uint64   // cdc: minimale Chunkgröße
+	AvgSize   uint64   // cdc: durchschnittliche Chunkgröße (Zweierpotenz)
+	MaxSize   uint64   // cdc: maximale Chunkgröße
+	Exclude   []string // Muster wie in .gitignore, die zusätzlich zu den .splitfuseignore Dateien gelten
+	Tolerant  bool     // Fehler bei einzelnen Elementen brechen den Scan nicht ab (siehe PartialScanError)
+	Retries   int      // so oft wird eine Datei neu gescannt, die sich während dem Scan verändert hat
+
+	Checkpoint *CheckpointConfig // gehashte Dateien regelmäßig speichern und einen abgebrochenen Scan fortsetzen (nil: aus)
+	Progress   *Progress         // zählt die zu hashenden Dateien und bytes (nil: aus)
+}
+
+// DefaultScanConfig gibt die Standard-Einstellungen zurück (feste Chunkgröße).
+// Die cdc Werte werden nur verwendet, wenn Chunking auf ChunkingCDC gesetzt wird.
+func DefaultScanConfig() ScanConfig {
+	return ScanConfig{
+		Jobs:      1,
+		Retries:   3,
+		Chunking:  ChunkingFixed,
+		ChunkSize: CHUNKSIZE,
+		MinSize:   16777216,  // 16 Mebibyte
+		AvgSize:   67108864,  // 64 Mebibyte
+		MaxSize:   268435456, // 256 Mebibyte
+	}
+}
+
+// Check prüft die Einstellungen.
+func (c ScanConfig) Check() error {
+	for _, p := range c.Exclude {
+		if _, _, err := parseIgnoreRule(".", p); err != nil {
+			return errors.New("bad exclude pattern: " + p)
+		}
+	}
+	switch c.Chunking {
+	case ChunkingFixed:
+		return CheckChunkSize(c.ChunkSize)
+	case ChunkingCDC:
+		return checkCdcSizes(c.MinSize, c.AvgSize, c.MaxSize)
+	default:
+		return errors.New("unknown chunking: " + c.Chunking)
+	}
+}
+
+// newCutter gibt den chunkCutter für diese Einstellungen zurück.
+func (c ScanConfig) newCutter() chunkCutter {
+	if c.Chunking == ChunkingCDC {
+		return newCdcCutter(c)
+	}
+	return &fixedCutter{size: int(c.ChunkSize)}
+}
+
+// gibt den Ordnerinhalt zurück (ohne die Elemente, die der filter überspringt)
+func readDirNames(dirname string, relDir string, filter *scanFilter) ([]FolderContent, error) {
 	// Ordner öffnen
 	f, err := os.Open(dirname)
 	if err != nil {
@@ -38,22 +99,60 @@ func readDirNames(dirname string) ([]FolderContent, error) {
 	// FolderContent Liste anlegen
 	ret := make([]FolderContent, 0, len(names))
 	for _, v := range names {
-		// sub-element Datei oder Ordner?
+		// sub-element Datei, Link oder Ordner? (Links werden nicht verfolgt)
 		tmppath := filepath.Join(dirname, v)
-		info, err := os.Stat(tmppath)
+		info, err := os.Lstat(tmppath)
 		if err != nil {
 			return nil, err
 		}
-		isFile := !info.IsDir()
+		isLink := info.Mode()&os.ModeSymlink != 0
+		isFile := !info.IsDir() && !isLink
+		// übersprungene Elemente gehören nicht zum Ordnerinhalt
+		skip, err := filter.excluded(filepath.Join(relDir, v), info.IsDir())
+		if err != nil {
+			return nil, err
+		}
+		if skip {
+			continue
+		}
 		// hinzufügen
-		ret = append(ret, FolderContent{Name: v, IsFile: isFile})
+		ret = append(ret, FolderContent{Name: v, IsFile: isFile, IsLink: isLink})
 	}
 
 	return ret, nil
 }
 
-// ScanFolder scant einen ganzen Ordner und erstellt daraus eine db.
+// ScanFolder scant einen ganzen Ordner mit den Standard-Einstellungen und erstellt daraus eine db.
 func ScanFolder(rootpath string, db SfDb, debug bool) (newDB SfDb, changed bool, summary string, retErr error) {
+	return ScanFolderWithConfig(rootpath, db, DefaultScanConfig(), debug)
+}
+
+// ScanFolderWithConfig scant einen ganzen Ordner und erstellt daraus eine db.
+// Elemente, die durch cfg.Exclude oder eine .splitfuseignore Datei ausgeschlossen sind, werden übersprungen.
+// Dateien, die mit einem anderen Chunking in der alten DB stehen, werden neu gescannt.
+// Im tolerant Modus wird für Elemente mit Fehlern der alte Eintrag übernommen (oder sie fehlen)
+// und am Ende ein PartialScanError mit allen Fehlern zurück gegeben.
+func ScanFolderWithConfig(rootpath string, db SfDb, cfg ScanConfig, debug bool) (newDB SfDb, changed bool, summary string, retErr error) {
+	return scanFolder(rootpath, db, cfg, nil, debug)
+}
+
+// ScanPaths aktualisiert die db nur für die geänderten Pfade (relativ zum Root).
+// Ordner, in denen sich nichts geändert hat, werden nicht gelesen, sondern aus der alten DB übernommen.
+// Ein geänderter Ordner wird mit seinem ganzen Inhalt neu gescannt, "." scannt also alles.
+func ScanPaths(rootpath string, db SfDb, paths []string, cfg ScanConfig, debug bool) (newDB SfDb, changed bool, summary string, retErr error) {
+	return scanFolder(rootpath, db, cfg, newDirtySet(paths), debug)
+}
+
+// scanFolder scant den Ordner. Ist dirty nicht nil, dann werden nur die betroffenen Ordner gelesen.
+func scanFolder(rootpath string, db SfDb, cfg ScanConfig, dirty dirtySet, debug bool) (newDB SfDb, changed bool, summary string, retErr error) {
+	if retErr = cfg.Check(); retErr != nil {
+		return
+	}
+	checkpoint, retErr := newCheckpointer(cfg.Checkpoint, rootpath, debug)
+	if retErr != nil {
+		return
+	}
+
 	// clone oldDB
 	oldDB := make(SfDb, len(db))
 	for k, v := range db {
@@ -62,32 +161,90 @@ func ScanFolder(rootpath string, db SfDb, debug bool) (newDB SfDb, changed bool,
 
 	// init return values
 	countNewOrUpdate := 0
+	countResumed := 0
+	countMeta := 0
 	newDB = SfDb{}
+	var jobs []*scanJob // neue oder geänderte Dateien, die nach dem Walk gescannt werden
+	errs := &scanErrors{tolerant: cfg.Tolerant}
+	filter, retErr := newScanFilter(rootpath, cfg.Exclude, errs)
+	if retErr != nil {
+		return
+	}
 
 	// Walk
 	retErr = filepath.Walk(rootpath, func(path string, info os.FileInfo, err error) error {
-		// Fehlerbehandlung der WalkFunc
+		// relativen Pfad ermitteln
+		relPath, relErr := filepath.Rel(rootpath, path)
+		if relErr != nil {
+			return relErr
+		}
+
+		// Fehlerbehandlung der WalkFunc (im tolerant Modus bleibt der alte Eintrag erhalten)
 		if err != nil {
+			if err = errs.add(relPath, err); err == nil {
+				keepOld(oldDB, newDB, relPath)
+				if info != nil && info.IsDir() {
+					return filepath.SkipDir
+				}
+			}
 			return err
 		}
 
-		// relativen Pfad ermitteln
-		relPath, err := filepath.Rel(rootpath, path)
+		// Eckdaten des betrachteten Elements ermitteln (Walk verwendet Lstat, Links werden nicht verfolgt)
+		isLink := info.Mode()&os.ModeSymlink != 0
+		isFile := !info.IsDir() && !isLink
+
+		// übersprungene Elemente (und bei Ordnern der ganze Inhalt) kommen nicht in die DB
+		skip, err := filter.excluded(relPath, info.IsDir())
 		if err != nil {
+			// z.B. eine .splitfuseignore Datei, die nicht gelesen werden kann
+			if err = errs.add(relPath, err); err == nil {
+				keepOld(oldDB, newDB, relPath)
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+			}
 			return err
 		}
+		if skip {
+			scanDebug(debug, "excluded: "+relPath)
+			if !isFile {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// unveränderte Ordner (mit Inhalt) aus der alten DB übernehmen
+		if _, ok := oldDB[relPath]; ok && !isFile && dirty != nil && !dirty.touches(relPath) {
+			keepOld(oldDB, newDB, relPath)
+			return filepath.SkipDir
+		}
 
-		// Eckdaten des betrachteten Elements ermitteln
-		isFile := !info.IsDir()
 		mtime := uint64(info.ModTime().Unix())
 		size := uint64(info.Size())
 
+		// Ziel ermitteln, wenn es ein Link ist
+		var linkTarget string
+		if isLink {
+			linkTarget, err = os.Readlink(path)
+			if err != nil {
+				if err = errs.add(relPath, err); err == nil {
+					keepOld(oldDB, newDB, relPath)
+				}
+				return err
+			}
+		}
+
 		// Ordnerinhalt ermitteln, wenn es ein Ordner ist
 		var folderContent []FolderContent
-		if !isFile {
-			folderContent, err = readDirNames(path)
+		if info.IsDir() {
+			folderContent, err = readDirNames(path, relPath, filter)
 			if err != nil {
-				// Fehlerbehandlung der readDir Func
+				// Fehlerbehandlung der readDir Func (im tolerant Modus bleibt der alte Ordner erhalten)
+				if err = errs.add(relPath, err); err == nil {
+					keepOld(oldDB, newDB, relPath)
+					return filepath.SkipDir
+				}
 				return err
 			}
 		}
@@ -97,18 +254,29 @@ func ScanFolder(rootpath string, db SfDb, debug bool) (newDB SfDb, changed bool,
 
 		// Fälle, in denen das Element neu gelesen werden muss
 		// andernfalls kann das Element aus der alten DB übernommen werden
-		if !ok || e.Size != size || e.IsFile != isFile || e.Mtime != mtime {
+		// (die ns der mtime werden nur verglichen, wenn die alte DB sie schon kennt)
+		mtimeNsec := uint32(info.ModTime().Nanosecond())
+		rescan := !ok || e.Size != size || e.IsFile != isFile || e.Mtime != mtime || e.IsLink != isLink || e.LinkTarget != linkTarget || !cfg.matches(e) || (e.HasMeta && e.MtimeNsec != mtimeNsec)
+		if rescan {
 			countNewOrUpdate++
 			changed = true // Änderung festhalten
 			scanDebug(debug, "new or changed: "+relPath)
 
-			if isFile {
-				// Ist es eine Datei: Element scannen
-				e, err = scanFile(path)
-				if err != nil {
-					// Fehlerbehandlung der ScanFunc
-					return err
+			if r, resumed := checkpoint.reuse(relPath, size, mtime, mtimeNsec, cfg); resumed && isFile {
+				// schon von einem abgebrochenen Scan gehasht
+				countResumed++
+				e = r
+			} else if isLink {
+				// Links haben keinen Inhalt, nur ein Ziel
+				e = SfFile{
+					Size:       size,
+					Mtime:      mtime,
+					IsLink:     true,
+					LinkTarget: linkTarget,
 				}
+			} else if isFile {
+				// Ist es eine Datei: Element nach dem Walk (parallel) scannen
+				jobs = append(jobs, &scanJob{relPath: relPath, path: path, size: int64(size), old: e, hasOld: ok})
 			} else {
 				// ist es ein Ordner, dann neu baun
 				e = SfFile{
@@ -126,6 +294,16 @@ func ScanFolder(rootpath string, db SfDb, debug bool) (newDB SfDb, changed bool,
 		// Das mache ich so, well der Abgleich (equal) von folderContent nicht immer funktioniert
 		e.FolderContent = folderContent
 
+		// POSIX Metadaten übernehmen: Sie ändern sich (z.B. durch chmod) auch ohne neuen Inhalt.
+		// Einträge aus älteren DBs bekommen so beim nächsten Scan ihre Metadaten.
+		before := e
+		applyMeta(&e, info)
+		if !rescan && !sameMeta(before, e) {
+			countMeta++
+			changed = true
+			scanDebug(debug, "metadata changed: "+relPath)
+		}
+
 		// Ist die einzige Änderung, dass ein altes Element nicht mehr vorhanden ist,
 		// dann muss ich das auch erkennen können. Sollange also das changed Flag nicht andeweitig gesetzt wurde,
 		// muss ich alle übernommenen Elemente aus der alten Datenbank löschen. Bleibt am Ende etwas übrig, dann
@@ -137,13 +315,42 @@ func ScanFolder(rootpath string, db SfDb, debug bool) (newDB SfDb, changed bool,
 		return nil
 	})
 
+	// neue und geänderte Dateien scannen
+	if retErr == nil {
+		for _, j := range jobs {
+			cfg.Progress.AddTotal(1, j.size)
+		}
+		retErr = scanFiles(jobs, cfg, checkpoint, debug)
+		for _, j := range jobs {
+			if j.err == nil {
+				newDB[j.relPath] = j.result
+			} else if errs.add(j.relPath, j.err) == nil {
+				delete(newDB, j.relPath)
+				if j.hasOld {
+					newDB[j.relPath] = j.old
+				}
+			}
+		}
+		if cfg.Tolerant {
+			fixFolderContent(newDB, errs.errs)
+			retErr = errs.err()
+		}
+	}
+
+	// abgebrochener Scan: die gehashten Dateien für den nächsten Versuch speichern
+	if _, partial := retErr.(*PartialScanError); retErr != nil && !partial {
+		if err := checkpoint.flush(); err != nil {
+			scanDebug(debug, "checkpoint: "+err.Error())
+		}
+	}
+
 	// finale changed?
 	if len(oldDB) > 0 {
 		changed = true
 	}
 
 	// Statistik
-	summary = fmt.Sprintf("SCAN: error=%v, sum=%d, changed=%v, newOrUpdate=%d, removed=%d", retErr, len(newDB), changed, countNewOrUpdate, len(oldDB))
+	summary = fmt.Sprintf("SCAN: error=%v, sum=%d, changed=%v, newOrUpdate=%d, removed=%d, failed=%d, resumed=%d, metadata=%d", retErr, len(newDB), changed, countNewOrUpdate, len(oldDB), len(errs.errs), countResumed, countMeta)
 	return
 }
 
@@ -153,8 +360,20 @@ func scanDebug(debug bool, msg string) {
 	}
 }
 
+// matches prüft, ob eine Datei aus der alten DB mit diesem Chunking (und dieser Chunkgröße) gescannt wurde.
+// Bei cdc werden die Grenzen nicht verglichen: geänderte min/avg/max Werte gelten nur für neue Dateien.
+func (c ScanConfig) matches(e SfFile) bool {
+	if !e.IsFile || len(e.FileChunks) == 0 {
+		return true
+	}
+	if c.Chunking == ChunkingCDC {
+		return e.ChunkOffsets != nil
+	}
+	return e.ChunkOffsets == nil && e.FixedChunkSize() == c.ChunkSize
+}
+
 // scanFile liest eine Klartextdatei und berechnet die hashes der einzelnen Chunks
-func scanFile(path string) (SfFile, error) {
+func scanFile(path string, cfg ScanConfig) (SfFile, error) {
 
 	// Datei zum Lesen öffnen
 	fh, err := os.Open(path)
@@ -165,41 +384,50 @@ func scanFile(path string) (SfFile, error) {
 
 	// Datei in Chunks teilen und hash berechnen
 	var fileSize int64 = 0
-	var chunkSize = 0
+	var chunkStart int64 = 0
 	var chunkHash = sha512.New()
 	var chunkList = make([]ChunkHash, 0)
+	var chunkOffsets []uint64
+	var cutter = cfg.newCutter()
+	var buffer = make([]byte, BUFFERSIZE)
+
+	// Chunk abschließen
+	// ABER: leere Dateien müssen eine leere Chunk-Liste haben
+	// UND chunks mit der größe 0 dürfen auch nicht
+	finishChunk := func() {
+		if fileSize > chunkStart {
+			sfChunk, _ := Sha512ToChunkHash(chunkHash.Sum(nil))
+			chunkList = append(chunkList, sfChunk)
+			chunkOffsets = append(chunkOffsets, uint64(chunkStart))
+		}
+		// reset vars
+		chunkStart = fileSize
+		chunkHash = sha512.New()
+	}
 
 	for {
 		// buffer-weise den chunk lesen
-		buffer := make([]byte, BUFFERSIZE)
 		n, readErr := fh.Read(buffer)
-		buffer = buffer[:n] // buffer ist nur so groß, wie auch wirklich gelesen wurde
-
-		// hash weiter berechnen
-		if n > 0 {
-			fileSize += int64(n)
-			chunkSize += n
-			chunkHash.Write(buffer)
-		}
-
-		// Chunk abschließen?  wegen Größe oder EOF
-		if chunkSize >= CHUNKSIZE || readErr != nil {
-			// add hash to list
-			// ABER: leere Dateien müssen eine leere Chunk-Liste haben
-			// UND chunks mit der größe 0 dürfen auch nicht
-			sfChunk, _ := Sha512ToChunkHash(chunkHash.Sum(nil))
-			if fileSize > 0 && chunkSize > 0 {
-				chunkList = append(chunkList, sfChunk)
+		data := buffer[:n] // data ist nur so groß, wie auch wirklich gelesen wurde
+		cfg.Progress.Add(0, int64(n))
+
+		// hash weiter berechnen und an den Chunkgrenzen abschließen
+		for len(data) > 0 {
+			cut := cutter.cut(data)
+			if cut < 0 {
+				fileSize += int64(len(data))
+				chunkHash.Write(data)
+				break
 			}
-
-			// reset vars
-			chunkSize = 0
-			chunkHash = sha512.New()
-
+			fileSize += int64(cut)
+			chunkHash.Write(data[:cut])
+			finishChunk()
+			data = data[cut:]
 		}
 
 		// Lesen der Datei ist abgeschlossen (EOF)
 		if readErr != nil {
+			finishChunk()
 			break
 		}
 	}
@@ -215,11 +443,19 @@ func scanFile(path string) (SfFile, error) {
 		return SfFile{}, errors.New("file was not completely read: " + path)
 	}
 
-	// SfFile Objekt erzeugen und zurück geben
-	return SfFile{
+	// SfFile Objekt erzeugen
+	e := SfFile{
 		Size:       uint64(fileSize),
-		Mtime:      uint64(fileInfo.ModTime().Unix()),
 		IsFile:     !fileInfo.IsDir(),
 		FileChunks: chunkList,
-	}, nil
+	}
+	applyMeta(&e, fileInfo)
+
+	// Bei fester Chunkgröße werden die Grenzen nicht gespeichert, sondern nur die Größe (wenn sie nicht CHUNKSIZE ist)
+	if cfg.Chunking == ChunkingCDC {
+		e.ChunkOffsets = chunkOffsets
+	} else if cfg.ChunkSize != CHUNKSIZE {
+		e.ChunkSize = cfg.ChunkSize
+	}
+	return e, nil
 }
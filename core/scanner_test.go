@@ -42,7 +42,7 @@ func TestScanFolder(t *testing.T) {
 
 func TestScanFileTime(t *testing.T) {
 	// leer.testfile
-	ol, err := scanFile(emptyTestFile)
+	ol, err := scanFile(emptyTestFile, DefaultScanConfig())
 	if err != nil {
 		panic(err)
 	}
@@ -54,7 +54,7 @@ func TestScanFileTime(t *testing.T) {
 	}
 
 	// test.keyfile
-	ot, err := scanFile(testKeyFile)
+	ot, err := scanFile(testKeyFile, DefaultScanConfig())
 	if err != nil {
 		panic(err)
 	}
@@ -66,7 +66,7 @@ func TestScanFileTime(t *testing.T) {
 	}
 
 	// testfail.keyfile
-	of, err := scanFile(failKeyFile)
+	of, err := scanFile(failKeyFile, DefaultScanConfig())
 	if err != nil {
 		panic(err)
 	}
@@ -79,7 +79,7 @@ func TestScanFileTime(t *testing.T) {
 }
 
 func TestScanFileHash(t *testing.T) {
-	ol, err := scanFile(emptyTestFile)
+	ol, err := scanFile(emptyTestFile, DefaultScanConfig())
 	if err != nil {
 		panic(err)
 	}
@@ -87,7 +87,7 @@ func TestScanFileHash(t *testing.T) {
 		t.Errorf("leer.testfile hash wrong")
 	}
 
-	ot, err := scanFile(testKeyFile)
+	ot, err := scanFile(testKeyFile, DefaultScanConfig())
 	ht, _ := hex.DecodeString("DD5610DABC3B5C9BF4F567AAD68AABA0489DD5B9C6552C8C8B6AC4EC6DFA71430C827DD2675BA6760BB635C59964218A3F17F6B995932F5C47CFEF666761CE69")
 	if err != nil {
 		panic(err)
@@ -96,7 +96,7 @@ func TestScanFileHash(t *testing.T) {
 		t.Errorf("test.keyfile hash wrong")
 	}
 
-	of, err := scanFile(failKeyFile)
+	of, err := scanFile(failKeyFile, DefaultScanConfig())
 	hf, _ := hex.DecodeString("B9866826DEA338E79ACB1244C8DCA98465BD32BA722E03DCD6DE2CBEDD189E72C37343AC63C857653DE09BBBFF53FFC151493B2494CAC0702490689184C96069")
 	if err != nil {
 		panic(err)
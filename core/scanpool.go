@@ -0,0 +1,202 @@
+package core
+
+import (
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// Wird für Dateien zurück gegeben, die nach einem Fehler nicht mehr gescannt wurden.
+var errScanAborted = errors.New("scan aborted")
+
+// scanJob ist eine neue oder geänderte Datei, die gescannt werden muss.
+type scanJob struct {
+	relPath string
+	path    string
+	size    int64  // Größe beim Walk (für den Fortschritt)
+	old     SfFile // Eintrag aus der alten DB (für den tolerant Modus)
+	hasOld  bool
+	result  SfFile
+	err     error
+}
+
+// scanFiles scannt alle Dateien mit cfg.Jobs Workern. Bei einer großen Datei mit fester Chunkgröße
+// werden auch die Chunks parallel gehasht. Insgesamt werden nie mehr als cfg.Jobs Chunks gleichzeitig gehasht.
+// Das Ergebnis steht in den Jobs und ist unabhängig von der Anzahl der Worker. Jede gehashte Datei kommt in den checkpoint.
+// Im tolerant Modus werden nach einem Fehler die übrigen Dateien trotzdem gescannt.
+// Zurück gegeben wird der Fehler der ersten Datei (in der Reihenfolge der Jobs), die nicht gescannt werden konnte.
+func scanFiles(jobs []*scanJob, cfg ScanConfig, checkpoint *checkpointer, debug bool) error {
+	workers := cfg.Jobs
+	if workers < 1 {
+		workers = 1
+	}
+	sem := make(chan struct{}, workers) // begrenzt das gleichzeitige Hashen
+	queue := make(chan *scanJob)
+	var failed int32
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				// nach einem Fehler nichts mehr anfangen
+				if atomic.LoadInt32(&failed) != 0 {
+					j.err = errScanAborted
+					continue
+				}
+				scanDebug(debug, "scan: "+j.relPath)
+				j.result, j.err = scanFileStable(j.path, cfg, sem, debug)
+				cfg.Progress.Add(1, 0)
+				if j.err == nil {
+					checkpoint.add(j.relPath, j.result)
+				} else if !cfg.Tolerant {
+					atomic.StoreInt32(&failed, 1)
+				}
+			}
+		}()
+	}
+	for _, j := range jobs {
+		queue <- j
+	}
+	close(queue)
+	wg.Wait()
+
+	for _, j := range jobs {
+		if j.err != nil && j.err != errScanAborted {
+			return j.err
+		}
+	}
+	return nil
+}
+
+// scanFileStable scannt eine Datei und prüft, ob sie sich dabei verändert hat (Größe, mtime in ns und Inode).
+// Dann wird der Scan bis zu cfg.Retries mal wiederholt. Ändert sie sich jedes Mal, dann kommt ein UnstableFileError.
+// Die bytes eines wiederholten Versuchs werden im Progress wieder abgezogen.
+func scanFileStable(path string, cfg ScanConfig, sem chan struct{}, debug bool) (SfFile, error) {
+	progress := cfg.Progress
+	for attempt := 1; ; attempt++ {
+		before, err := statFileID(path)
+		if err != nil {
+			return SfFile{}, err
+		}
+		cfg.Progress = progress.attempt()
+		e, scanErr := scanFileParallel(path, cfg, sem)
+		after, err := statFileID(path)
+		if err != nil {
+			return SfFile{}, err
+		}
+		if before == after {
+			return e, scanErr // auch ein Fehler ist dann keine Folge einer Änderung
+		}
+		if attempt > cfg.Retries {
+			return SfFile{}, &UnstableFileError{Path: path, Attempts: attempt}
+		}
+		cfg.Progress.undo()
+		scanDebug(debug, "changed during scan, retry: "+path)
+	}
+}
+
+// scanFileParallel scannt eine Datei. Mit fester Chunkgröße und cfg.Jobs > 1 werden die Chunks einer großen Datei
+// von höchstens cfg.Jobs Workern parallel gehasht, sonst wird scanFile verwendet. Für jeden Chunk wird ein Platz in sem belegt.
+func scanFileParallel(path string, cfg ScanConfig, sem chan struct{}) (SfFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return SfFile{}, err
+	}
+	chunkSize := int64(cfg.ChunkSize)
+	if cfg.Jobs <= 1 || cfg.Chunking == ChunkingCDC || info.Size() <= chunkSize {
+		sem <- struct{}{}
+		defer func() { <-sem }()
+		return scanFile(path, cfg)
+	}
+
+	// die Chunks auf die Worker verteilen
+	size := info.Size()
+	count := int((size + chunkSize - 1) / chunkSize)
+	chunkList := make([]ChunkHash, count)
+	errs := make([]error, count)
+	indexes := make(chan int)
+	var failed int32 // nach einem Fehler werden die restlichen Chunks übersprungen
+	var wg sync.WaitGroup
+	workers := cfg.Jobs
+	if workers > count {
+		workers = count
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if atomic.LoadInt32(&failed) != 0 {
+					continue
+				}
+				sem <- struct{}{}
+				offset := int64(i) * chunkSize
+				length := chunkSize
+				if offset+length > size {
+					length = size - offset
+				}
+				chunkList[i], errs[i] = hashFileSection(path, offset, length, cfg.Progress)
+				<-sem
+				if errs[i] != nil {
+					atomic.StoreInt32(&failed, 1)
+				}
+			}
+		}()
+	}
+	for i := 0; i < count; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return SfFile{}, err
+		}
+	}
+
+	// Prüfungen: Hat sich die Größe geändert?
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return SfFile{}, err
+	}
+	if fileInfo.Size() != size {
+		return SfFile{}, errors.New("file was not completely read: " + path)
+	}
+
+	// SfFile Objekt erzeugen und zurück geben
+	e := SfFile{
+		Size:       uint64(size),
+		IsFile:     !fileInfo.IsDir(),
+		FileChunks: chunkList,
+	}
+	applyMeta(&e, fileInfo)
+	if cfg.ChunkSize != CHUNKSIZE {
+		e.ChunkSize = cfg.ChunkSize
+	}
+	return e, nil
+}
+
+// hashFileSection berechnet den sha512 Hash über einen Teil einer Datei. Die gelesenen bytes kommen in p.
+func hashFileSection(path string, offset int64, length int64, p *Progress) (ChunkHash, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return ChunkHash{}, err
+	}
+	defer fh.Close()
+
+	h := sha512.New()
+	n, err := io.CopyBuffer(h, progressReader{io.NewSectionReader(fh, offset, length), p}, make([]byte, BUFFERSIZE))
+	if err != nil {
+		return ChunkHash{}, err
+	}
+	if n != length {
+		return ChunkHash{}, fmt.Errorf("file was not completely read: %s (%d of %d bytes at %d)", path, n, length, offset)
+	}
+	return Sha512ToChunkHash(h.Sum(nil))
+}
@@ -0,0 +1,52 @@
+package core
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Das Ergebnis darf nicht von der Anzahl der Jobs abhängen.
+func TestScanFolderJobs(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "scanpool.test")
+	defer os.RemoveAll(dir)
+
+	rnd := rand.New(rand.NewSource(3))
+	os.Mkdir(filepath.Join(dir, "sub"), 0700)
+	for i, size := range []int{0, 1, 131072, 131073, 700000, 2000000} {
+		data := make([]byte, size)
+		rnd.Read(data)
+		ioutil.WriteFile(filepath.Join(dir, string(rune('a'+i))), data, 0600)
+		ioutil.WriteFile(filepath.Join(dir, "sub", string(rune('a'+i))), data[:size/2], 0600)
+	}
+
+	fixed := DefaultScanConfig()
+	fixed.ChunkSize = 131072
+	for _, cfg := range []ScanConfig{fixed, testCdcConfig} {
+		cfg.Jobs = 1
+		serial, _, _, err := ScanFolderWithConfig(dir, SfDb{}, cfg, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.Jobs = 4
+		parallel, _, _, err := ScanFolderWithConfig(dir, SfDb{}, cfg, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(serial, parallel) {
+			t.Errorf("%s: parallel scan differs from serial scan", cfg.Chunking)
+		}
+	}
+	if f, _, _, _ := ScanFolderWithConfig(dir, SfDb{}, fixed, false); len(f["e"].FileChunks) != 6 || f["e"].ChunkSize != 131072 {
+		t.Errorf("wrong chunks: %d, size %d", len(f["e"].FileChunks), f["e"].ChunkSize)
+	}
+
+	// ein Fehler muss gemeldet werden
+	fixed.Jobs = 4
+	if _, err := scanFileParallel(filepath.Join(dir, "missing"), fixed, make(chan struct{}, 4)); err == nil {
+		t.Error("missing file should fail")
+	}
+}
@@ -0,0 +1,130 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"io"
+)
+
+// ChunkCorruptError wird zurück gegeben, wenn der entschlüsselte Inhalt eines Chunks nicht zum ChunkHash passt.
+type ChunkCorruptError struct {
+	Reason string
+}
+
+func (e *ChunkCorruptError) Error() string {
+	return "chunk is corrupt: " + e.Reason
+}
+
+// IsChunkCorrupt prüft, ob der Fehler ein ChunkCorruptError ist.
+func IsChunkCorrupt(err error) bool {
+	_, ok := err.(*ChunkCorruptError)
+	return ok
+}
+
+// VerifyChunk liest einen ganzen Chunk aus dem Store, entschlüsselt ihn und vergleicht den sha512 Hash
+// über den Klartext mit dem ChunkHash aus der DB. Auch die Größe muss genau stimmen.
+// Passt der Inhalt nicht, dann wird ein ChunkCorruptError zurück gegeben.
+// Andere Fehler (z.B. Lesefehler) werden unverändert zurück gegeben.
+func VerifyChunk(store ChunkStore, chunkName []byte, chunkKey []byte, h ChunkHash, size uint64) error {
+	_, err := VerifyChunkBlocks(store, chunkName, chunkKey, h, size)
+	return err
+}
+
+// VERIFYBLOCKSIZE ist die Größe der Blöcke, für die VerifyChunkBlocks je einen eigenen Hash berechnet.
+const VERIFYBLOCKSIZE = CACHEBLOCKSIZE
+
+// BlockHash ist der sha256 Hash über den Klartext eines Blocks (VERIFYBLOCKSIZE) eines Chunks.
+type BlockHash [sha256.Size]byte
+
+// VerifyChunkBlocks prüft einen Chunk wie VerifyChunk und gibt zusätzlich für jeden Block den BlockHash zurück.
+// Damit kann später jeder einzelne Block geprüft werden, ohne den ganzen Chunk noch einmal zu lesen.
+func VerifyChunkBlocks(store ChunkStore, chunkName []byte, chunkKey []byte, h ChunkHash, size uint64) ([]BlockHash, error) {
+	hf := sha512.New()
+	buf := make([]byte, VERIFYBLOCKSIZE)
+	var blocks []BlockHash
+
+	var offset int64
+	for {
+		n, err := store.ReadChunk(chunkName, offset, buf)
+		if n > 0 {
+			CryptBytes(buf[:n], offset, chunkKey)
+			hf.Write(buf[:n])
+			blocks = append(blocks, sha256.Sum256(buf[:n]))
+			offset += int64(n)
+		}
+		if err == io.EOF || (err == nil && n < len(buf)) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if uint64(offset) > size {
+			break
+		}
+	}
+
+	// Größe und Hash prüfen
+	if uint64(offset) != size {
+		return nil, &ChunkCorruptError{Reason: fmt.Sprintf("size is %d, expected %d", offset, size)}
+	}
+	if !bytes.Equal(hf.Sum(nil), h[:]) {
+		return nil, &ChunkCorruptError{Reason: "hash mismatch"}
+	}
+	return blocks, nil
+}
+
+// ChunkProblem beschreibt ein Problem mit einem Chunk im ChunkStore und welche Klartext Dateien betroffen sind.
+type ChunkProblem struct {
+	Name    ChunkHash // verschlüsselter Chunkname
+	Problem string    // z.B. "missing"
+	Paths   []string  // betroffene Klartext Dateien (sortiert)
+}
+
+// VerifyStore prüft, ob alle Chunks der DB im Store vorhanden sind und die richtige Größe haben.
+// Mit deep wird jeder Chunk zusätzlich entschlüsselt und der Hash über den Klartext geprüft.
+// Es wird ein Fehler zurück gegeben, wenn der Store nicht aufgelistet werden kann.
+func VerifyStore(db SfDb, k KeyFile, store ChunkStore, deep bool, debug bool) (problems []ChunkProblem, summary string, retErr error) {
+	refs := collectChunkRefs(db, k)
+
+	// alle vorhandenen Chunks auf einmal auflisten (schneller als jeden Chunk einzeln abzufragen)
+	list, retErr := store.ListChunks()
+	if retErr != nil {
+		return
+	}
+	sizes := make(map[ChunkHash]int64, len(list))
+	for _, info := range list {
+		sizes[info.Name] = info.Size
+	}
+
+	countMissing := 0
+	countSize := 0
+	countCorrupt := 0
+	for _, r := range refs {
+		ch, _ := Sha512ToChunkHash(r.name)
+		problem := ""
+
+		if size, ok := sizes[ch]; !ok {
+			problem = "missing"
+			countMissing++
+		} else if uint64(size) != r.size {
+			problem = fmt.Sprintf("wrong size %d (expected %d)", size, r.size)
+			countSize++
+		} else if deep {
+			scanDebug(debug, fmt.Sprintf("verify chunk %x", r.name))
+			if err := VerifyChunk(store, r.name, k.CalcChunkKey(r.hash[:]), r.hash, r.size); err != nil {
+				problem = err.Error()
+				countCorrupt++
+			}
+		}
+
+		if problem != "" {
+			problems = append(problems, ChunkProblem{Name: ch, Problem: problem, Paths: r.paths})
+		}
+	}
+
+	// Statistik
+	summary = fmt.Sprintf("VERIFY: chunks=%d, missing=%d, wrongSize=%d, corrupt=%d, deep=%v", len(refs), countMissing, countSize, countCorrupt, deep)
+	return
+}
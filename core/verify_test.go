@@ -0,0 +1,100 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyChunk(t *testing.T) {
+	k := LoadKeyfile(testKeyFile)
+
+	// einen Chunk mit push erzeugen
+	rootdir, _ := ioutil.TempDir("", "verify.test")
+	defer os.RemoveAll(rootdir)
+	ioutil.WriteFile(filepath.Join(rootdir, "a.txt"), []byte("Inhalt, der geprüft werden soll."), 0600)
+	vdb, _, _, _ := ScanFolder(rootdir, SfDb{}, false)
+
+	dir := newTestChunkDir(t)
+	defer os.RemoveAll(dir)
+	store, _ := NewDirChunkStore(dir)
+	if _, err := PushChunks(vdb, k, rootdir, store, false); err != nil {
+		t.Fatal(err)
+	}
+
+	f := vdb["a.txt"]
+	h := f.FileChunks[0]
+	name := k.CalcChunkCryptHash(h[:])
+	key := k.CalcChunkKey(h[:])
+
+	// alles ok
+	if err := VerifyChunk(store, name, key, h, f.Size); err != nil {
+		t.Errorf("verify ok: %v", err)
+	}
+
+	// falsche Größe
+	if err := VerifyChunk(store, name, key, h, f.Size+1); !IsChunkCorrupt(err) {
+		t.Errorf("verify size: %v", err)
+	}
+
+	// ein byte verändern
+	path := store.ChunkPath(name)
+	data, _ := ioutil.ReadFile(path)
+	data[3] ^= 0xff
+	ioutil.WriteFile(path, data, 0600)
+	if err := VerifyChunk(store, name, key, h, f.Size); !IsChunkCorrupt(err) {
+		t.Errorf("verify corrupt: %v", err)
+	}
+
+	// Chunk fehlt: das ist ein Lesefehler und kein beschädigter Chunk
+	os.Remove(path)
+	if err := VerifyChunk(store, name, key, h, f.Size); err == nil || IsChunkCorrupt(err) {
+		t.Errorf("verify missing: %v", err)
+	}
+}
+
+func TestVerifyStore(t *testing.T) {
+	k := LoadKeyfile(testKeyFile)
+
+	// drei Dateien, davon zwei mit gleichem Inhalt
+	rootdir, _ := ioutil.TempDir("", "verify.test")
+	defer os.RemoveAll(rootdir)
+	ioutil.WriteFile(filepath.Join(rootdir, "a.txt"), []byte("gleicher Inhalt"), 0600)
+	ioutil.WriteFile(filepath.Join(rootdir, "b.txt"), []byte("gleicher Inhalt"), 0600)
+	ioutil.WriteFile(filepath.Join(rootdir, "c.txt"), []byte("anderer Inhalt"), 0600)
+	vdb, _, _, _ := ScanFolder(rootdir, SfDb{}, false)
+
+	dir := newTestChunkDir(t)
+	defer os.RemoveAll(dir)
+	store, _ := NewDirChunkStore(dir)
+	PushChunks(vdb, k, rootdir, store, false)
+
+	// alles ok
+	problems, _, err := VerifyStore(vdb, k, store, true, false)
+	if err != nil || len(problems) != 0 {
+		t.Errorf("verify ok: %v, %v", problems, err)
+	}
+
+	// gemeinsamer Chunk fehlt: beide Dateien sind betroffen
+	h := vdb["a.txt"].FileChunks[0]
+	os.Remove(store.ChunkPath(k.CalcChunkCryptHash(h[:])))
+	// anderer Chunk ist beschädigt (gleiche Größe)
+	h = vdb["c.txt"].FileChunks[0]
+	path := store.ChunkPath(k.CalcChunkCryptHash(h[:]))
+	data, _ := ioutil.ReadFile(path)
+	data[0] ^= 0xff
+	ioutil.WriteFile(path, data, 0600)
+
+	// ohne deep wird nur das Fehlen erkannt
+	problems, _, _ = VerifyStore(vdb, k, store, false, false)
+	if len(problems) != 1 || problems[0].Problem != "missing" || len(problems[0].Paths) != 2 || problems[0].Paths[0] != "a.txt" {
+		t.Errorf("verify missing: %v", problems)
+	}
+
+	// mit deep auch der beschädigte Chunk
+	problems, _, _ = VerifyStore(vdb, k, store, true, false)
+	if len(problems) != 2 || problems[1].Paths[0] != "c.txt" {
+		t.Errorf("verify deep: %v", problems)
+	}
+}
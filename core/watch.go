@@ -0,0 +1,151 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// WatchOptions enthält die Einstellungen für WatchFolder.
+type WatchOptions struct {
+	Debounce    time.Duration // so lange muss es nach einer Änderung ruhig sein, bevor gescannt wird
+	Interval    time.Duration // so oft wird eine geänderte DB höchstens gespeichert
+	InitialScan bool          // zuerst einmal alles scannen (und eine geänderte DB gleich speichern)
+}
+
+// WatchFolder aktualisiert db mit ScanPaths, sobald über events geänderte Pfade kommen (z.B. von einem Watcher).
+// Die Pfade werden gesammelt, bis opts.Debounce lang keine neuen kommen. Eine geänderte DB wird höchstens alle
+// opts.Interval mit save gespeichert. Scan Fehler werden ausgegeben und die Pfade beim nächsten Mal wieder versucht.
+// events wird auch während eines Scans laufend gelesen, damit der Kernel keine Ereignisse verwirft.
+// Wird stop geschlossen oder events geschlossen, dann wird noch einmal gescannt und gespeichert.
+// Nur ein Fehler von save beendet WatchFolder vorzeitig.
+func WatchFolder(rootpath string, db SfDb, cfg ScanConfig, opts WatchOptions, events <-chan string, stop <-chan struct{}, save func(SfDb) error, debug bool) error {
+	var mux sync.Mutex
+	dirty := make(map[string]bool) // geänderte Pfade (mux)
+	unsaved := false
+	lastSave := time.Now()
+
+	// events sammeln (auch während gescannt wird)
+	changes := make(chan struct{}, 1)
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for p := range events {
+			scanDebug(debug, "changed: "+p)
+			mux.Lock()
+			dirty[p] = true
+			mux.Unlock()
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	pending := func() bool {
+		mux.Lock()
+		defer mux.Unlock()
+		return len(dirty) > 0
+	}
+
+	// die gesammelten Pfade scannen
+	scan := func() {
+		mux.Lock()
+		todo := dirty
+		dirty = make(map[string]bool)
+		mux.Unlock()
+		if len(todo) == 0 {
+			return
+		}
+		paths := make([]string, 0, len(todo))
+		for p := range todo {
+			paths = append(paths, p)
+		}
+		newDB, changed, summary, err := ScanPaths(rootpath, db, paths, cfg, debug)
+		if partial, ok := err.(*PartialScanError); ok {
+			for _, e := range partial.Errors {
+				println("ERROR: " + e.Error())
+			}
+		} else if err != nil {
+			println(summary)
+			// später nochmal versuchen
+			mux.Lock()
+			for p := range todo {
+				dirty[p] = true
+			}
+			mux.Unlock()
+			return
+		}
+		scanDebug(debug, summary)
+		db = newDB
+		if changed {
+			unsaved = true
+		}
+	}
+
+	// speichern, wenn sich etwas geändert hat
+	flush := func(force bool) error {
+		if !unsaved || (!force && time.Since(lastSave) < opts.Interval) {
+			return nil
+		}
+		if err := save(db); err != nil {
+			return err
+		}
+		unsaved = false
+		lastSave = time.Now()
+		return nil
+	}
+
+	debounce := time.NewTimer(opts.Debounce)
+	if !debounce.Stop() {
+		<-debounce.C
+	}
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	// zuerst alles scannen, die Ereignisse werden dabei schon gesammelt
+	if opts.InitialScan {
+		mux.Lock()
+		dirty["."] = true
+		mux.Unlock()
+		scan()
+		if err := flush(true); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-changes:
+			resetTimer(debounce, opts.Debounce)
+		case <-closed:
+			scan()
+			return flush(true)
+		case <-debounce.C:
+			scan()
+			if err := flush(false); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if pending() {
+				resetTimer(debounce, opts.Debounce) // fehlgeschlagenen Scan wiederholen
+			}
+			if err := flush(false); err != nil {
+				return err
+			}
+		case <-stop:
+			scan()
+			return flush(true)
+		}
+	}
+}
+
+// resetTimer startet t neu. Ein schon abgelaufener, aber noch nicht gelesener Wert wird vorher verworfen,
+// sonst würde gleich danach ein zusätzlicher Scan ausgelöst.
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
@@ -0,0 +1,152 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// Diese Ereignisse betreffen den Inhalt eines Ordners (oder einer Datei darin).
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY | syscall.IN_ATTRIB | syscall.IN_CREATE |
+	syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// Watcher beobachtet alle Ordner unter root mit inotify.
+// Über Events kommen die geänderten Pfade (relativ zu root). "." bedeutet, dass alles neu gescannt werden muss
+// (z.B. wenn der Kernel Ereignisse verworfen hat).
+type Watcher struct {
+	Events <-chan string
+	Errors <-chan error
+
+	root    string
+	fd      int
+	file    *os.File // zum Lesen (Close beendet ein blockierendes Read)
+	events  chan string
+	errors  chan error
+	mux     sync.Mutex
+	watches map[int32]string // watch descriptor -> Ordner (relativ zu root)
+}
+
+// NewWatcher beobachtet root und alle Unterordner. Neue Ordner werden automatisch hinzugefügt.
+func NewWatcher(root string) (*Watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	events := make(chan string, 1024)
+	errors := make(chan error, 16)
+	w := &Watcher{
+		Events:  events,
+		Errors:  errors,
+		root:    root,
+		fd:      fd,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		events:  events,
+		errors:  errors,
+		watches: make(map[int32]string),
+	}
+	if err := w.addTree("."); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+	go w.readEvents()
+	return w, nil
+}
+
+// Close beendet die Beobachtung, danach wird Events geschlossen.
+func (w *Watcher) Close() error {
+	return w.file.Close()
+}
+
+// addTree beobachtet den Ordner relDir und alle Unterordner.
+func (w *Watcher) addTree(relDir string) error {
+	return filepath.Walk(filepath.Join(w.root, relDir), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil // schon wieder gelöscht
+			}
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyMask)
+		if err != nil {
+			return os.NewSyscallError("inotify_add_watch "+path, err)
+		}
+		rel, _ := filepath.Rel(w.root, path)
+		w.mux.Lock()
+		w.watches[int32(wd)] = rel
+		w.mux.Unlock()
+		return nil
+	})
+}
+
+func (w *Watcher) readEvents() {
+	defer close(w.events)
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return // Close
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(ev.Len)]
+			offset += syscall.SizeofInotifyEvent + int(ev.Len)
+			w.handle(ev.Wd, ev.Mask, string(trimNul(nameBytes)))
+		}
+	}
+}
+
+func (w *Watcher) handle(wd int32, mask uint32, name string) {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		w.events <- "."
+		return
+	}
+	w.mux.Lock()
+	dir, ok := w.watches[wd]
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(w.watches, wd)
+	}
+	w.mux.Unlock()
+	if !ok {
+		return
+	}
+
+	// Ereignisse am beobachteten Ordner selbst
+	if name == "" {
+		if mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF) != 0 {
+			w.events <- dir
+		}
+		return
+	}
+
+	relPath := filepath.Join(dir, name)
+	switch {
+	case name == IgnoreFileName:
+		// die Muster gelten für den ganzen Ordner
+		w.events <- dir
+	case mask&syscall.IN_ISDIR != 0 && mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+		// neuer Ordner: beobachten und ganz scannen
+		if err := w.addTree(relPath); err != nil {
+			select {
+			case w.errors <- err:
+			default:
+			}
+		}
+		w.events <- relPath
+	default:
+		w.events <- relPath
+	}
+}
+
+func trimNul(b []byte) []byte {
+	for i, c := range b {
+		if c == 0 {
+			return b[:i]
+		}
+	}
+	return b
+}
@@ -0,0 +1,55 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// wartet auf den Pfad want (andere Ereignisse werden ignoriert)
+func waitForEvent(t *testing.T, w *Watcher, want string) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case p := <-w.Events:
+			if p == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("no event for %s", want)
+		}
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "watcher.test")
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "a"), 0700)
+
+	w, err := NewWatcher(dir)
+	if err != nil {
+		t.Skip("inotify not available: " + err.Error())
+	}
+	defer w.Close()
+
+	ioutil.WriteFile(filepath.Join(dir, "a", "1.txt"), []byte("1"), 0600)
+	waitForEvent(t, w, filepath.Join("a", "1.txt"))
+
+	// neue Ordner werden auch beobachtet
+	os.Mkdir(filepath.Join(dir, "new"), 0700)
+	waitForEvent(t, w, "new")
+	ioutil.WriteFile(filepath.Join(dir, "new", "2.txt"), []byte("2"), 0600)
+	waitForEvent(t, w, filepath.Join("new", "2.txt"))
+
+	ioutil.WriteFile(filepath.Join(dir, "new", IgnoreFileName), []byte("*.tmp"), 0600)
+	waitForEvent(t, w, "new")
+
+	os.Remove(filepath.Join(dir, "a", "1.txt"))
+	waitForEvent(t, w, filepath.Join("a", "1.txt"))
+
+	w.Close()
+	for range w.Events {
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package core
+
+import "errors"
+
+// Watcher beobachtet alle Ordner unter root (nur unter Linux mit inotify).
+type Watcher struct {
+	Events <-chan string
+	Errors <-chan error
+}
+
+// NewWatcher gibt auf diesem System immer einen Fehler zurück.
+func NewWatcher(root string) (*Watcher, error) {
+	return nil, errors.New("watch is only supported on linux")
+}
+
+// Close beendet die Beobachtung.
+func (w *Watcher) Close() error {
+	return nil
+}
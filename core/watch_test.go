@@ -0,0 +1,147 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// erstellt einen Ordner mit a/1.txt, b/2.txt und scannt ihn
+func watchTestDir(t *testing.T) (string, SfDb) {
+	dir, _ := ioutil.TempDir("", "watch.test")
+	os.Mkdir(filepath.Join(dir, "a"), 0700)
+	os.Mkdir(filepath.Join(dir, "b"), 0700)
+	ioutil.WriteFile(filepath.Join(dir, "a", "1.txt"), []byte("1"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "b", "2.txt"), []byte("2"), 0600)
+	db, _, _, err := ScanFolder(dir, SfDb{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, db
+}
+
+func TestScanPaths(t *testing.T) {
+	dir, db := watchTestDir(t)
+	defer os.RemoveAll(dir)
+	a1 := filepath.Join("a", "1.txt")
+	b2 := filepath.Join("b", "2.txt")
+
+	// b wird nicht gelesen: der falsche Eintrag bleibt erhalten
+	fake := db[b2]
+	fake.Size = 42
+	db[b2] = fake
+	ioutil.WriteFile(filepath.Join(dir, "a", "1.txt"), []byte("11"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "a", "3.txt"), []byte("3"), 0600)
+
+	newDB, changed, _, err := ScanPaths(dir, db, []string{a1, filepath.Join("a", "3.txt")}, DefaultScanConfig(), false)
+	if err != nil || !changed {
+		t.Fatalf("changed=%v, err=%v", changed, err)
+	}
+	if newDB[a1].Size != 2 || len(newDB["a"].FolderContent) != 2 {
+		t.Error("a was not updated")
+	}
+	if newDB[b2].Size != 42 {
+		t.Error("b should not be scanned")
+	}
+
+	// gelöschter Ordner
+	os.RemoveAll(filepath.Join(dir, "b"))
+	newDB, _, _, err = ScanPaths(dir, newDB, []string{"b"}, DefaultScanConfig(), false)
+	if _, ok := newDB[b2]; ok || err != nil || len(newDB["."].FolderContent) != 1 {
+		t.Errorf("b should be removed: %v", err)
+	}
+
+	// "." scannt alles
+	full, _, _, _ := ScanFolder(dir, SfDb{}, false)
+	newDB, changed, _, _ = ScanPaths(dir, newDB, []string{"."}, DefaultScanConfig(), false)
+	if changed || len(newDB) != len(full) {
+		t.Errorf("full rescan: changed=%v, %d entries", changed, len(newDB))
+	}
+}
+
+func TestWatchFolder(t *testing.T) {
+	dir, db := watchTestDir(t)
+	defer os.RemoveAll(dir)
+
+	events := make(chan string)
+	saved := make(chan SfDb, 10)
+	save := func(db SfDb) error {
+		saved <- db
+		return nil
+	}
+	opts := WatchOptions{Debounce: 10 * time.Millisecond, Interval: time.Hour}
+	done := make(chan error)
+	go func() {
+		done <- WatchFolder(dir, db, DefaultScanConfig(), opts, events, nil, save, false)
+	}()
+
+	ioutil.WriteFile(filepath.Join(dir, "b", "4.txt"), []byte("4"), 0600)
+	events <- filepath.Join("b", "4.txt")
+	events <- "b"
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-saved:
+		t.Fatal("saved before interval")
+	default:
+	}
+
+	// am Ende wird gespeichert
+	close(events)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case db := <-saved:
+		if _, ok := db[filepath.Join("b", "4.txt")]; !ok {
+			t.Error("b/4.txt is missing")
+		}
+	default:
+		t.Error("not saved")
+	}
+}
+
+// Während dem ersten Scan (und beim Speichern) werden die Ereignisse schon gesammelt
+func TestWatchFolderInitialScan(t *testing.T) {
+	dir, _ := watchTestDir(t)
+	defer os.RemoveAll(dir)
+
+	events := make(chan string)
+	saving := make(chan SfDb, 10)
+	release := make(chan bool)
+	save := func(db SfDb) error {
+		saving <- db
+		<-release
+		return nil
+	}
+	opts := WatchOptions{Debounce: 10 * time.Millisecond, Interval: time.Hour, InitialScan: true}
+	done := make(chan error)
+	go func() {
+		done <- WatchFolder(dir, SfDb{}, DefaultScanConfig(), opts, events, nil, save, false)
+	}()
+
+	// der erste Scan wird gleich gespeichert
+	if db := <-saving; len(db[filepath.Join("a", "1.txt")].FileChunks) != 1 {
+		t.Errorf("initial scan is missing: %v", db)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "b", "4.txt"), []byte("4"), 0600)
+	for _, p := range []string{filepath.Join("b", "4.txt"), "b"} {
+		select {
+		case events <- p:
+		case <-time.After(time.Second):
+			t.Fatal("events are not read while saving")
+		}
+	}
+	release <- true
+
+	close(events)
+	db := <-saving
+	release <- true
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := db[filepath.Join("b", "4.txt")]; !ok {
+		t.Error("b/4.txt is missing")
+	}
+}
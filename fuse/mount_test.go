@@ -257,7 +257,11 @@ func mountReverse(t *testing.T) {
 func mountNormal(t *testing.T) {
 
 	// mount NORMAL
-	server := MountNormal(dbfilepath, keyfilepath, mnt1cp, mnt2, false, true)
+	store, err := core.NewDirChunkStore(mnt1cp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := MountNormal(dbfilepath, keyfilepath, store, mnt2, NormalOptions{Prefetch: 16 * 1024 * 1024}, false, true)
 	go server.Serve()
 	server.WaitMount()
 
@@ -2,10 +2,11 @@ package fuse
 
 import (
 	"os"
+	"io"
 	"time"
 	"sync"
+	"sync/atomic"
 	"fmt"
-	"path/filepath"
 
 	"github.com/SchnorcherSepp/splitfuse/core"
 	"github.com/hanwen/go-fuse/fuse"
@@ -18,13 +19,15 @@ const maxLastFhCache = 12
 // SplitFile wird von der Open() Funktion zurück gegeben
 // und stellt die Read() Funktion zur verfügung..
 type SplitFile struct {
-	debug       bool
-	dbFile      core.SfFile
-	chunkFolder string
-	chunkKeys   [][]byte
-	chunkNames  [][]byte
+	debug      bool
+	dbFile     core.SfFile
+	store      core.ChunkStore
+	chunkKeys  [][]byte
+	chunkNames [][]byte
+	prefetch   *prefetcher    // nil, wenn der Read-Ahead ausgeschaltet ist
+	verifier   *chunkVerifier // nil, wenn die Chunks nicht geprüft werden
 	lastFh [maxLastFhCache]struct {
-		fh        *os.File
+		fh        core.ChunkHandle
 		chunkNr   int
 		nextChOff int64
 	}
@@ -46,6 +49,49 @@ func (f *SplitFile) Release() {
 		}
 	}
 	f.lastFhMux.Unlock() // THREAD SAFE: end
+
+	if f.prefetch != nil {
+		f.prefetch.release()
+	}
+}
+
+// readAt liest ab offset (Position in der Klartext Datei) über ChunkStore.ReadChunk und entschlüsselt die Daten.
+// Dabei werden auch Chunkgrenzen überschritten. Wird vom Read-Ahead verwendet.
+func (f *SplitFile) readAt(buf []byte, offset int64) (int, error) {
+	n := 0
+	for n < len(buf) {
+		chunkNr, chunkOffset := f.dbFile.ChunkAt(uint64(offset) + uint64(n))
+		if chunkNr >= len(f.chunkNames) {
+			break
+		}
+
+		// nicht über das Chunkende hinaus lesen
+		_, chunkSize := f.dbFile.ChunkBounds(chunkNr)
+		want := int64(len(buf) - n)
+		if want > int64(chunkSize-chunkOffset) {
+			want = int64(chunkSize - chunkOffset)
+		}
+		part := buf[n : n+int(want)]
+
+		var m int
+		var err error
+		if f.verifier != nil {
+			// nur geprüfte Daten (--verify)
+			m, err = f.verifier.read(f.chunkNames[chunkNr], f.chunkKeys[chunkNr], f.dbFile.FileChunks[chunkNr], chunkSize, int64(chunkOffset), part)
+		} else {
+			m, err = f.store.ReadChunk(f.chunkNames[chunkNr], int64(chunkOffset), part)
+			core.CryptBytes(part[:m], int64(chunkOffset), f.chunkKeys[chunkNr])
+		}
+		n += m
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+		if int64(m) < want {
+			// Chunk ist kürzer als erwartet
+			break
+		}
+	}
+	return n, nil
 }
 
 // Read liest bytes und gibt sie fürs FUSE zurück.
@@ -58,10 +104,17 @@ func (f *SplitFile) Read(buf []byte, offset int64) (fuse.ReadResult, fuse.Status
 		return fuse.ReadResultData([]byte{}), fuse.OK
 	}
 
+	// Daten aus dem Read-Ahead?
+	if f.prefetch != nil {
+		if data, ok := f.prefetch.read(offset, len(buf)); ok {
+			return fuse.ReadResultData(data), fuse.OK
+		}
+	}
+
 	// Berechnungen
 	readLength := int64(len(buf))
-	chunkOffset := offset % core.CHUNKSIZE
-	chunkNr := int((offset - chunkOffset) / core.CHUNKSIZE)
+	chunkNr, uChunkOffset := f.dbFile.ChunkAt(uint64(offset))
+	chunkOffset := int64(uChunkOffset)
 
 	// FIX: Es gibt den Fall, dass am Ende noch einmal 4096 bytes über die Datei gelesen werden.
 	// Dabei kann es vorkommen, dass sich die ChunkNr erhöht und es dazu keine Daten in chunkKey und chunkName gibt.
@@ -71,11 +124,21 @@ func (f *SplitFile) Read(buf []byte, offset int64) (fuse.ReadResult, fuse.Status
 		return fuse.ReadResultData([]byte{}), fuse.OK
 	}
 
+	// mit --verify werden nur geprüfte Blöcke zurück gegeben (über readAt statt über die file handler)
+	if f.verifier != nil {
+		n, err := f.readAt(buf, offset)
+		if err != nil {
+			debug(f.debug, "ERROR: "+err.Error())
+			return fuse.ReadResultData([]byte{}), fuse.EIO
+		}
+		return fuse.ReadResultData(buf[:n]), fuse.OK
+	}
+
 	// Daten ermitteln
+	_, chunkSize := f.dbFile.ChunkBounds(chunkNr)
 	chunkKey := f.chunkKeys[chunkNr]
 	chunkName := f.chunkNames[chunkNr]
 	chunkNameHex := fmt.Sprintf("%x", chunkName)
-	chunPath := filepath.Join(f.chunkFolder, chunkNameHex[:2], chunkNameHex)
 
 	// Ich muss nun auf den chunk zugreifen und brauche dafür ein file-open
 	// Da diese Operation teuer ist, speichere ich alte filehandler und verwende sie wieder, wenn es geht
@@ -99,7 +162,7 @@ func (f *SplitFile) Read(buf []byte, offset int64) (fuse.ReadResult, fuse.Status
 		// der gespeicherte file handler ist geeignet
 		n, err := f.lastFh[foundPerfectFh].fh.Read(buf)
 		if err != nil && n > 0 {
-			debug(f.debug, fmt.Sprintf("ERROR: read error with recycled fh: n=%d, chunkOff=%d, chunkNr=%d, e=%s, p=%s", n, chunkOffset, chunkNr, err.Error(), chunPath))
+			debug(f.debug, fmt.Sprintf("ERROR: read error with recycled fh: n=%d, chunkOff=%d, chunkNr=%d, e=%s, p=%s", n, chunkOffset, chunkNr, err.Error(), chunkNameHex))
 			openErr = err
 			f.lastFh[foundPerfectFh].fh = nil
 		}
@@ -122,10 +185,10 @@ func (f *SplitFile) Read(buf []byte, offset int64) (fuse.ReadResult, fuse.Status
 		}
 
 		// neuen fh öffnen, der auf Pos 0 kommt
-		fh, err := os.Open(chunPath)
+		fh, err := f.store.OpenChunk(chunkName)
 		f.lastFh[f.nextFhIndex].fh = fh
 		if err != nil {
-			debug(f.debug, fmt.Sprintf("ERROR: open error: chunkOff=%d, chunkNr=%d, e=%s, p=%s", chunkOffset, chunkNr, err.Error(), chunPath))
+			debug(f.debug, fmt.Sprintf("ERROR: open error: chunkOff=%d, chunkNr=%d, e=%s, p=%s", chunkOffset, chunkNr, err.Error(), chunkNameHex))
 			openErr = err
 			f.lastFh[f.nextFhIndex].fh = nil
 
@@ -135,7 +198,7 @@ func (f *SplitFile) Read(buf []byte, offset int64) (fuse.ReadResult, fuse.Status
 
 			// chunck offset setzen
 			if _, err := fh.Seek(chunkOffset, 0); err != nil {
-				debug(f.debug, fmt.Sprintf("ERROR: seek error: chunkOff=%d, chunkNr=%d, e=%s, p=%s", chunkOffset, chunkNr, err.Error(), chunPath))
+				debug(f.debug, fmt.Sprintf("ERROR: seek error: chunkOff=%d, chunkNr=%d, e=%s, p=%s", chunkOffset, chunkNr, err.Error(), chunkNameHex))
 				openErr = err
 				f.lastFh[f.nextFhIndex].fh = nil
 
@@ -145,7 +208,7 @@ func (f *SplitFile) Read(buf []byte, offset int64) (fuse.ReadResult, fuse.Status
 				// Daten lesen
 				n, err := fh.Read(buf)
 				if err != nil && n > 0 {
-					debug(f.debug, fmt.Sprintf("ERROR: read error with new fh: n=%d, chunkOff=%d, chunkNr=%d, e=%s, p=%s", n, chunkOffset, chunkNr, err.Error(), chunPath))
+					debug(f.debug, fmt.Sprintf("ERROR: read error with new fh: n=%d, chunkOff=%d, chunkNr=%d, e=%s, p=%s", n, chunkOffset, chunkNr, err.Error(), chunkNameHex))
 					openErr = err
 					f.lastFh[f.nextFhIndex].fh = nil
 				}
@@ -179,7 +242,7 @@ func (f *SplitFile) Read(buf []byte, offset int64) (fuse.ReadResult, fuse.Status
 
 	// SONDERFALL: was ist, wenn knapp über einen chunk hinaus gelesen werden soll?
 	// dann muss eine weitere abfrage abgesetzt werden!
-	nextChunkBufferSize := chunkOffset + readLength - core.CHUNKSIZE
+	nextChunkBufferSize := chunkOffset + readLength - int64(chunkSize)
 	if nextChunkBufferSize > 0 {
 		debug(f.debug, fmt.Sprintf("SPECIAL READ: %d", nextChunkBufferSize))
 
@@ -204,19 +267,78 @@ func (f *SplitFile) Read(buf []byte, offset int64) (fuse.ReadResult, fuse.Status
 
 // SplitFs ist ein pathfs und hier sind fast alle eigenen FUSE Funktionen gebunden.
 type SplitFs struct {
-	debug        bool         // zusätzliche Meldungen einblenden
-	db           core.SfDb    // Datenbank
-	dbpath       string       // Pfad zur DB, um sie regelmäßig neu einzulesen
-	intervall    int64        // update intervall in Sekunden  (bei 0 wird der Defaultwert genommen)
-	lastDbUpdate int64        // wann wurde zuletzt checkDbUpdate() ausgeführt (Unix Time)
-	lastDbMtime  int64        // die mtime des zuletzt geladenen DB files
-	keyfile      core.KeyFile // Keyfile mit allen Schlüsseln
-	chunkfolder  string       // Pfad zu den Chunks
+	debug        bool            // zusätzliche Meldungen einblenden
+	db           atomic.Value    // Datenbank (core.SfDb), wird nie verändert sondern nur komplett ersetzt
+	dbpath       string          // Pfad zur DB, um sie regelmäßig neu einzulesen
+	intervall    int64           // update intervall in Sekunden  (bei 0 wird der Defaultwert genommen)
+	updating     int32           // 1, solange checkDbUpdate() läuft (atomic)
+	lastDbUpdate int64           // wann wurde zuletzt checkDbUpdate() ausgeführt (Unix Time)
+	lastDbMtime  int64           // die mtime des zuletzt geladenen DB files
+	keyfile      core.KeyFile    // Keyfile mit allen Schlüsseln
+	store        core.ChunkStore // Zugriff auf die Chunks
+	opts         NormalOptions   // optionale Einstellungen
+	verifier     *chunkVerifier  // prüft die Chunks (nil, wenn --verify nicht gesetzt ist)
+	snapshots    *snapshots      // Generationen unter /.snapshots (nil, wenn ausgeschaltet)
 	pathfs.FileSystem
 }
 
+// getDb gibt die aktuelle DB zurück.
+// Da eine DB nie verändert, sondern bei einem Update komplett ersetzt wird, kann sie ohne Lock gelesen werden.
+func (fs *SplitFs) getDb() core.SfDb {
+	db, _ := fs.db.Load().(core.SfDb)
+	return db
+}
+
+// lookup sucht einen Pfad in der aktuellen DB oder (unterhalb von .snapshots) in einer Generation.
+func (fs *SplitFs) lookup(name string) (core.SfFile, bool) {
+	// FIX: root
+	if name == "" {
+		name = "."
+	}
+
+	// Generationen
+	if fs.snapshots != nil {
+		if gen, dbName, ok := fs.snapshots.split(name); ok {
+			if gen == "" {
+				return fs.snapshots.folder(fs.updateIntervall()), true
+			}
+			db, err := fs.snapshots.load(gen)
+			if err != nil {
+				debug(fs.debug, "ERROR: snapshot "+gen+": "+err.Error())
+				return core.SfFile{}, false
+			}
+			dbFile, ok := db[dbName]
+			return dbFile, ok
+		}
+	}
+
+	// aktuelle DB
+	dbFile, ok := fs.getDb()[name]
+	if ok && name == "." && fs.snapshots != nil {
+		// .snapshots im Root einblenden (Kopie, die DB darf nicht verändert werden)
+		// ein echter Eintrag mit diesem Namen ist verdeckt und wird nicht aufgelistet
+		content := make([]core.FolderContent, 0, len(dbFile.FolderContent)+1)
+		for _, c := range dbFile.FolderContent {
+			if c.Name != snapshotDir {
+				content = append(content, c)
+			}
+		}
+		dbFile.FolderContent = append(content, core.FolderContent{Name: snapshotDir})
+	}
+	return dbFile, ok
+}
+
+// updateIntervall gibt zurück, wie oft (in Sekunden) die DB und die Generationen neu gelesen werden.
+func (fs *SplitFs) updateIntervall() int64 {
+	if fs.intervall > 0 {
+		return fs.intervall
+	}
+	return 5 * 60
+}
+
 // Diese Funktion wird von openDir getriggert
 // Dabei stellt sie sicher, dass sie nur alle x sekunden einen Effekt hat
+// Läuft bereits ein Update in einem anderen Thread, dann wird sofort 1 zurück gegeben.
 // return:
 //   0 ... Erfolgreich
 //   1 ... Intervall noch nicht erreicht
@@ -224,15 +346,15 @@ type SplitFs struct {
 //   3 ... DBfile existiert nicht
 //   4 ... Fehler beim Laden der DB
 func (fs *SplitFs) checkDbUpdate() int {
-	// check intervall
-	var intervall int64 = 5 * 60
-	if fs.intervall > 0 {
-		intervall = fs.intervall
+	// nur ein Update gleichzeitig
+	if !atomic.CompareAndSwapInt32(&fs.updating, 0, 1) {
+		return 1
 	}
+	defer atomic.StoreInt32(&fs.updating, 0)
 
 	// update nur alle 5 Minuten versuchen, egal ob erfolgreich oder nicht
 	now := time.Now().Unix()
-	thenPlus := fs.lastDbUpdate + intervall
+	thenPlus := fs.lastDbUpdate + fs.updateIntervall()
 	if thenPlus > now {
 		// nur alle x Sekunden erlauben
 		return 1
@@ -263,13 +385,18 @@ func (fs *SplitFs) checkDbUpdate() int {
 		return 4
 	}
 
-	// neue DB setzen
-	fs.db = newdb
+	// neue DB setzen (atomar, laufende Zugriffe arbeiten mit der alten DB weiter)
+	fs.db.Store(newdb)
 
 	// ACHTUNG: Nachdem die DB gesetzt wurde, muss nun auch fs.lastDbMtime gespeichert werden
 	// Vorher darf das nicht passieren, weil sonst die DB nicht geladen wird im Fehlerfall
 	fs.lastDbMtime = newDbMtime
 
+	// mit der neuen DB gibt es meist auch eine neue Generation
+	if fs.snapshots != nil {
+		fs.snapshots.reset()
+	}
+
 	// log schreiben (debug=true)
 	debug(fs.debug, "update db")
 
@@ -279,13 +406,8 @@ func (fs *SplitFs) checkDbUpdate() int {
 
 // GetAttr gibt die File-Attribute fr Eintrge aus der DB zurück.
 func (fs *SplitFs) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.Status) {
-	// FIX: root
-	if name == "" {
-		name = "."
-	}
-
 	// Element in der DB suchen
-	dbFile, ok := fs.db[name]
+	dbFile, ok := fs.lookup(name)
 	if !ok {
 		return nil, fuse.ENOENT
 	}
@@ -296,15 +418,34 @@ func (fs *SplitFs) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse
 	// Basis-Attribute setzen
 	ret.Size = dbFile.Size
 	ret.Mtime = dbFile.Mtime
+	ret.Mtimensec = dbFile.MtimeNsec
 	ret.Ctime = dbFile.Mtime
+	ret.Ctimensec = dbFile.MtimeNsec
 	ret.Atime = dbFile.Mtime
+	ret.Atimensec = dbFile.MtimeNsec
+	if dbFile.HasMeta {
+		ret.Ctime = dbFile.Ctime
+		ret.Ctimensec = dbFile.CtimeNsec
+	}
+
+	// Besitzer aus der DB (ohne Metadaten oder mit MapOwner: der Benutzer, der mountet)
+	if dbFile.HasMeta && !fs.opts.MapOwner {
+		ret.Owner = fuse.Owner{Uid: dbFile.Uid, Gid: dbFile.Gid}
+	} else {
+		ret.Owner = *fuse.CurrentOwner()
+	}
 
-	// Mode (Datei/Ordner)
-	if dbFile.IsFile {
-		ret.Mode = fuse.S_IFREG | 0644
+	// Mode (Datei/Link/Ordner) und Rechte (ohne Metadaten 0644 bzw. 0755)
+	if dbFile.IsLink {
+		// Links haben immer 0777 und als Größe die Länge des Ziels
+		ret.Mode = fuse.S_IFLNK | 0777
+		ret.Size = uint64(len(dbFile.LinkTarget))
+		ret.Nlink = 1
+	} else if dbFile.IsFile {
+		ret.Mode = fuse.S_IFREG | dbFile.Perm()
 		ret.Nlink = 1
 	} else {
-		ret.Mode = fuse.S_IFDIR | 0755
+		ret.Mode = fuse.S_IFDIR | dbFile.Perm()
 		ret.Nlink = uint32(len(dbFile.FolderContent))
 	}
 
@@ -316,19 +457,14 @@ func (fs *SplitFs) OpenDir(name string, context *fuse.Context) (c []fuse.DirEntr
 	// db update triggern
 	fs.checkDbUpdate()
 
-	// FIX: root
-	if name == "" {
-		name = "."
-	}
-
 	// Ordner in der DB suchen
-	dbFile, ok := fs.db[name]
+	dbFile, ok := fs.lookup(name)
 	if !ok {
 		return nil, fuse.ENOENT
 	}
 
 	// prüfen, ob es e ein Ordner ist
-	if dbFile.IsFile {
+	if dbFile.IsFile || dbFile.IsLink {
 		return nil, fuse.ENOTDIR
 	}
 
@@ -339,9 +475,11 @@ func (fs *SplitFs) OpenDir(name string, context *fuse.Context) (c []fuse.DirEntr
 	for _, v := range dbFile.FolderContent {
 		// Sub-Element erzeugen
 		tmp := fuse.DirEntry{Name: v.Name}
-		// Mode setzen (Datei oder Ordner)
+		// Mode setzen (Datei, Link oder Ordner)
 		// Nur das höchste Bit (eg. S_IFDIR) wird ausgewertet
-		if v.IsFile {
+		if v.IsLink {
+			tmp.Mode = fuse.S_IFLNK
+		} else if v.IsFile {
 			tmp.Mode = fuse.S_IFREG
 		} else {
 			tmp.Mode = fuse.S_IFDIR
@@ -353,17 +491,33 @@ func (fs *SplitFs) OpenDir(name string, context *fuse.Context) (c []fuse.DirEntr
 	return c, fuse.OK
 }
 
+// Readlink liefert das Ziel eines symbolischen Links.
+func (fs *SplitFs) Readlink(name string, context *fuse.Context) (string, fuse.Status) {
+	// Link in der DB suchen
+	dbFile, ok := fs.lookup(name)
+	if !ok {
+		return "", fuse.ENOENT
+	}
+
+	// prüfen, ob es e ein Link ist
+	if !dbFile.IsLink {
+		return "", fuse.EINVAL
+	}
+
+	return dbFile.LinkTarget, fuse.OK
+}
+
 // Öffnet eine Datei und berechnet dabei alle Informationen, um auf die Chunks zuzugreifen.
 func (fs *SplitFs) Open(name string, flags uint32, context *fuse.Context) (file nodefs.File, code fuse.Status) {
 
 	// Datei in der DB suchen
-	dbFile, ok := fs.db[name]
+	dbFile, ok := fs.lookup(name)
 	if !ok {
 		return nil, fuse.ENOENT
 	}
 
 	// prüfen, ob es e eine Datei ist
-	if !dbFile.IsFile {
+	if !dbFile.IsFile || dbFile.IsLink {
 		return nil, fuse.ENOENT
 	}
 
@@ -375,15 +529,24 @@ func (fs *SplitFs) Open(name string, flags uint32, context *fuse.Context) (file
 		chunkNames[i] = fs.keyfile.CalcChunkCryptHash(chunkhash[:])
 	}
 
+	// Datei erzeugen
+	f := &SplitFile{
+		File:       nodefs.NewDefaultFile(),
+		debug:      fs.debug,
+		store:      fs.store,
+		dbFile:     dbFile,
+		chunkKeys:  chunkKeys,
+		chunkNames: chunkNames,
+		verifier:   fs.verifier,
+	}
+
+	// Read-Ahead
+	if fs.opts.Prefetch > 0 {
+		f.prefetch = newPrefetcher(int64(dbFile.Size), fs.opts.Prefetch, f.readAt, fs.debug)
+	}
+
 	// Datei zurück geben
-	return &SplitFile{
-		File:        nodefs.NewDefaultFile(),
-		debug:       fs.debug,
-		chunkFolder: fs.chunkfolder,
-		dbFile:      dbFile,
-		chunkKeys:   chunkKeys,
-		chunkNames:  chunkNames,
-	}, fuse.OK
+	return f, fuse.OK
 }
 
 // Informationen für 'df -h'
@@ -391,7 +554,7 @@ func (fs *SplitFs) StatFs(name string) *fuse.StatfsOut {
 
 	// Summe aller Dateien berechnen
 	var sum uint64 = 0
-	for _, v := range fs.db {
+	for _, v := range fs.getDb() {
 		sum += v.Size
 	}
 
@@ -410,19 +573,16 @@ func (fs *SplitFs) StatFs(name string) *fuse.StatfsOut {
 	}
 }
 
-// MountNormal greift auf Chunks zu und mountet die Klartextdateien
-func MountNormal(dbpath string, keyfile string, chunkfolder string, mountpoint string, debug bool, test bool) *fuse.Server {
-
-	// Prüft, ob der Chunk Ordner richtig ist
-	// Es müssen die ganzen 00 .. ff Ordner vorhanden sein
-	testfolder := []string{"00", "47", "83", "a0", "de", "ff"}
-	for _, t := range testfolder {
-		_, e := os.Stat(filepath.Join(chunkfolder, t))
-		if e != nil {
-			// Ordner existiert nicht
-			panic("Wrong chunk folder! Can't find sub folder " + t)
-		}
-	}
+// NormalOptions enthält die optionalen Einstellungen für MountNormal.
+type NormalOptions struct {
+	Prefetch  int64 // so viele bytes werden bei sequentiellem Lesen im Hintergrund vorausgelesen (0: aus)
+	Verify    bool  // jeder Chunk wird vor dem ersten Lesen komplett geprüft (sha512 über den Klartext), danach jeder gelesene Block
+	Snapshots bool  // die Generationen der DB (scan --generations) unter /.snapshots einblenden
+	MapOwner  bool  // alle Elemente gehören dem Benutzer, der mountet (statt Besitzer und Gruppe aus der DB)
+}
+
+// MountNormal greift über den ChunkStore auf Chunks zu und mountet die Klartextdateien
+func MountNormal(dbpath string, keyfile string, store core.ChunkStore, mountpoint string, opts NormalOptions, debug bool, test bool) *fuse.Server {
 
 	// Keyfile laden
 	k := core.LoadKeyfile(keyfile)
@@ -434,33 +594,42 @@ func MountNormal(dbpath string, keyfile string, chunkfolder string, mountpoint s
 	}
 
 	// OPTIONEN
-	opts := &fuse.MountOptions{
-		FsName:         "SplitFuse", // erste Spalte bei 'df -hT'
-		Name:           "splitfsv2", // zweite Spalte bei 'df -hT'
-		MaxReadAhead:   131072,
-		Debug:          debug,
-		AllowOther:     true,
-		SingleThreaded: true,
+	mountOpts := &fuse.MountOptions{
+		FsName:       "SplitFuse", // erste Spalte bei 'df -hT'
+		Name:         "splitfsv2", // zweite Spalte bei 'df -hT'
+		MaxReadAhead: 131072,
+		Debug:        debug,
+		AllowOther:   true,
 	}
 
 	// SplitFS erzeugen  (mit meinen Methoden)
 	fs := &SplitFs{
-		FileSystem:  pathfs.NewDefaultFileSystem(),
-		debug:       debug,
-		db:          db,
-		dbpath:      dbpath,
-		keyfile:     k,
-		chunkfolder: chunkfolder,
+		FileSystem: pathfs.NewDefaultFileSystem(),
+		debug:      debug,
+		dbpath:     dbpath,
+		keyfile:    k,
+		store:      store,
+		opts:       opts,
+	}
+	fs.db.Store(db)
+	if opts.Verify {
+		fs.verifier = newChunkVerifier(store, debug)
+	}
+	if opts.Snapshots {
+		fs.snapshots = newSnapshots(dbpath, k.DbKey(), debug)
 	}
 
 	// Als Zwischenschicht, (dann ist alles ein wenig einfacher), kommt NewPathNodeFs zum Einsatz
 	nfs := pathfs.NewPathNodeFs(fs, nil)
 
 	// NewFileSystemConnector erzeugen
-	fsconn := nodefs.NewFileSystemConnector(nfs.Root(), nil)
+	// Ohne Owner setzt GetAttr den Besitzer selbst (sonst gehört alles dem Benutzer, der mountet)
+	nodeOpts := nodefs.NewOptions()
+	nodeOpts.Owner = nil
+	fsconn := nodefs.NewFileSystemConnector(nfs.Root(), nodeOpts)
 
 	// FUSE mit den Optionen mounten
-	server, err := fuse.NewServer(fsconn.RawFS(), mountpoint, opts)
+	server, err := fuse.NewServer(fsconn.RawFS(), mountpoint, mountOpts)
 	if err != nil {
 		panic(err)
 	}
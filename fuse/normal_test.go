@@ -1,11 +1,15 @@
 package fuse
 
 import (
+	"bytes"
+	"fmt"
+	"math/rand"
 	"testing"
 	"io/ioutil"
 	"time"
 	"os"
 	"github.com/SchnorcherSepp/splitfuse/core"
+	"github.com/hanwen/go-fuse/fuse"
 	"path/filepath"
 )
 
@@ -64,3 +68,184 @@ func TestCheckDbUpdate(t *testing.T) {
 	}
 
 }
+
+// Parallele Zugriffe auf die DB, während sie ausgetauscht wird (go test -race)
+func TestDbSwapParallel(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "swaptestfile.dat")
+	defer os.Remove(path)
+
+	fs := &SplitFs{}
+	fs.intervall = 1
+	fs.dbpath = path
+	fs.keyfile = core.KeyFile{}
+	fs.db.Store(core.SfDb{".": core.SfFile{}})
+
+	// OpenDir löst die Updates aus
+	stop := make(chan bool)
+	done := make(chan bool)
+	for i := 0; i < 4; i++ {
+		go func() {
+			for {
+				select {
+				case <-stop:
+					done <- true
+					return
+				default:
+				}
+				if _, s := fs.GetAttr("", nil); s != fuse.OK {
+					t.Errorf("root not found")
+				}
+				fs.OpenDir("", nil)
+			}
+		}()
+	}
+
+	// DB mehrmals austauschen
+	for i := 1; i <= 3; i++ {
+		core.DbToFile(path, fs.keyfile.DbKey(), core.SfDb{".": core.SfFile{Mtime: uint64(i)}})
+		os.Chtimes(path, time.Unix(int64(i*1000), 0), time.Unix(int64(i*1000), 0))
+		time.Sleep(1100 * time.Millisecond)
+	}
+
+	close(stop)
+	for i := 0; i < 4; i++ {
+		<-done
+	}
+
+	// die letzte DB muss geladen sein
+	if fs.getDb()["."].Mtime != 3 {
+		t.Errorf("db not updated: %v", fs.getDb())
+	}
+}
+
+// Lesen von Dateien mit kleinen Chunks (normal und reverse)
+func TestReadChunking(t *testing.T) {
+	fixed := core.DefaultScanConfig()
+	fixed.ChunkSize = 131072
+	testReadChunking(t, fixed)
+	testReadChunking(t, core.ScanConfig{Chunking: core.ChunkingCDC, MinSize: 1024, AvgSize: 4096, MaxSize: 16384})
+}
+
+func testReadChunking(t *testing.T, cfg core.ScanConfig) {
+	k := core.LoadKeyfile("../testdata/test.keyfile")
+
+	// Klartext Datei mit vielen kleinen Chunks
+	rootdir, _ := ioutil.TempDir("", "cdc.test")
+	defer os.RemoveAll(rootdir)
+	data := make([]byte, 400000)
+	rand.New(rand.NewSource(3)).Read(data)
+	ioutil.WriteFile(filepath.Join(rootdir, "a.bin"), data, 0600)
+	db, _, _, err := core.ScanFolderWithConfig(rootdir, core.SfDb{}, cfg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Chunks schreiben
+	storedir, _ := ioutil.TempDir("", "cdc.test")
+	defer os.RemoveAll(storedir)
+	store, _ := core.CreateDirChunkStore(storedir)
+	if _, err := core.PushChunks(db, k, rootdir, store, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// normal: mit und ohne Read-Ahead
+	for _, prefetch := range []int64{0, 65536} {
+		fs := &SplitFs{keyfile: k, store: store, opts: NormalOptions{Prefetch: prefetch}}
+		fs.db.Store(db)
+		file, status := fs.Open("a.bin", 0, nil)
+		if status != fuse.OK {
+			t.Fatal(status)
+		}
+		got := make([]byte, 0, len(data))
+		for offset := 0; offset < len(data); {
+			buf := make([]byte, 7000)
+			res, status := file.Read(buf, int64(offset))
+			if status != fuse.OK {
+				t.Fatalf("read at %d: %v", offset, status)
+			}
+			b, _ := res.Bytes(buf)
+			got = append(got, b[:res.Size()]...)
+			offset += res.Size()
+			if res.Size() == 0 {
+				break
+			}
+		}
+		file.Release()
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s, prefetch=%d: wrong data (%d bytes)", cfg.Chunking, prefetch, len(got))
+		}
+	}
+
+	// reverse: jeder Chunk muss dem geschriebenen Chunk entsprechen
+	rfs := &ReverseFs{crypHashIndex: db.GetReverseSfDb(k), rootdir: rootdir, db: db}
+	for _, h := range db["a.bin"].FileChunks {
+		name := fmt.Sprintf("%x", k.CalcChunkCryptHash(h[:]))
+		file, status := rfs.Open(name[:2]+"/"+name, 0, nil)
+		if status != fuse.OK {
+			t.Fatal(status)
+		}
+		want, _ := ioutil.ReadFile(store.ChunkPath(k.CalcChunkCryptHash(h[:])))
+		buf := make([]byte, len(want)+4096) // mehr lesen als der Chunk groß ist
+		res, _ := file.Read(buf, 0)
+		got, _ := res.Bytes(buf)
+		if !bytes.Equal(got[:res.Size()], want) {
+			t.Errorf("%s: reverse chunk %s: wrong data", cfg.Chunking, name)
+		}
+	}
+}
+
+// Rechte, Besitzer und ns Zeiten aus der DB (oder die Standardwerte ohne Metadaten)
+func TestGetAttrMeta(t *testing.T) {
+	fs := &SplitFs{}
+	fs.db.Store(core.SfDb{
+		".":   core.SfFile{},
+		"old": core.SfFile{IsFile: true, Mtime: 100},
+		"new": core.SfFile{IsFile: true, Mtime: 100, HasMeta: true, Mode: 04750, Uid: 1234, Gid: 5678, MtimeNsec: 42, Ctime: 200, CtimeNsec: 7},
+	})
+	me := fuse.CurrentOwner()
+
+	a, _ := fs.GetAttr("old", nil)
+	if a.Mode != fuse.S_IFREG|0644 || a.Owner != *me || a.Ctime != 100 {
+		t.Errorf("old: wrong attr: %v", a)
+	}
+	a, _ = fs.GetAttr("", nil)
+	if a.Mode != fuse.S_IFDIR|0755 {
+		t.Errorf("root: wrong mode: %o", a.Mode)
+	}
+	a, _ = fs.GetAttr("new", nil)
+	if a.Mode != fuse.S_IFREG|04750 || a.Uid != 1234 || a.Gid != 5678 || a.Mtimensec != 42 || a.Ctime != 200 || a.Ctimensec != 7 {
+		t.Errorf("new: wrong attr: %v", a)
+	}
+	fs.opts.MapOwner = true
+	if a, _ = fs.GetAttr("new", nil); a.Owner != *me {
+		t.Errorf("owner should be mapped: %v", a.Owner)
+	}
+}
+
+// Links werden mit S_IFLNK und ihrem Ziel angezeigt
+func TestReadlink(t *testing.T) {
+	fs := &SplitFs{}
+	fs.db.Store(core.SfDb{
+		".":    core.SfFile{FolderContent: []core.FolderContent{{Name: "link", IsLink: true}, {Name: "file", IsFile: true}}},
+		"link": core.SfFile{IsLink: true, LinkTarget: "../ziel", Mtime: 100},
+		"file": core.SfFile{IsFile: true},
+	})
+
+	a, s := fs.GetAttr("link", nil)
+	if s != fuse.OK || a.Mode != fuse.S_IFLNK|0777 || a.Size != 7 {
+		t.Errorf("link: wrong attr: %v", a)
+	}
+	if target, s := fs.Readlink("link", nil); s != fuse.OK || target != "../ziel" {
+		t.Errorf("wrong target: %q %v", target, s)
+	}
+	if _, s := fs.Readlink("file", nil); s != fuse.EINVAL {
+		t.Errorf("readlink on file: %v", s)
+	}
+	if _, s := fs.OpenDir("link", nil); s != fuse.ENOTDIR {
+		t.Errorf("opendir on link: %v", s)
+	}
+	c, _ := fs.OpenDir("", nil)
+	if len(c) != 2 || c[0].Mode != fuse.S_IFLNK || c[1].Mode != fuse.S_IFREG {
+		t.Errorf("wrong dir entries: %v", c)
+	}
+}
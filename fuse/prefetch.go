@@ -0,0 +1,157 @@
+package fuse
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Der Read-Ahead wird in Blöcken dieser Größe geholt. Ein Block kann über eine Chunkgrenze gehen
+// (z.B. bei content-defined chunking), readAt liest dann aus beiden Chunks.
+const prefetchBlockSize = 1024 * 1024 // 1 Mebibyte
+
+// Ab so vielen aufeinander folgenden Reads gilt der Zugriff als sequentiell.
+const prefetchMinSeqReads = 2
+
+// prefetcher erkennt sequentielles Lesen einer Datei und holt die nächsten bytes im Hintergrund.
+// Die Blöcke werden über die Position in der Klartext Datei adressiert. Nähert sich das Lesen dem Ende
+// eines Chunks, dann liegen die nächsten Blöcke automatisch im nächsten Chunk.
+type prefetcher struct {
+	debug bool
+	size  int64                                       // Größe der Klartext Datei
+	ahead int64                                       // so viele bytes werden im Voraus gelesen
+	fetch func(buf []byte, offset int64) (int, error) // liest entschlüsselte Daten ab offset
+
+	mux        sync.Mutex
+	nextOffset int64                    // offset, den ein sequentieller Read als nächstes hätte
+	seqReads   int                      // Anzahl der aufeinander folgenden Reads
+	blocks     map[int64]*prefetchBlock // blockNr -> Block
+}
+
+// ein Block, der im Hintergrund geholt wird
+type prefetchBlock struct {
+	done chan struct{} // wird geschlossen, wenn data und err gesetzt sind
+	data []byte
+	err  error
+}
+
+func newPrefetcher(size int64, ahead int64, fetch func(buf []byte, offset int64) (int, error), debug bool) *prefetcher {
+	return &prefetcher{
+		debug:  debug,
+		size:   size,
+		ahead:  ahead,
+		fetch:  fetch,
+		blocks: make(map[int64]*prefetchBlock),
+	}
+}
+
+// read gibt die Daten ab offset zurück, wenn sie vom Read-Ahead abgedeckt sind.
+// Ist das nicht der Fall, dann ist ok false und der Aufrufer muss selbst lesen.
+func (p *prefetcher) read(offset int64, length int) (data []byte, ok bool) {
+	if offset >= p.size || length < 1 {
+		return nil, false
+	}
+	end := offset + int64(length)
+	if end > p.size {
+		end = p.size
+	}
+
+	p.mux.Lock()
+
+	// sequentiellen Zugriff erkennen
+	if offset == p.nextOffset {
+		p.seqReads++
+	} else {
+		p.seqReads = 0
+	}
+	p.nextOffset = end
+
+	// nur Blöcke im Fenster ab der aktuellen Position werden noch gebraucht
+	// (auch nach einem Sprung zurück, sonst wächst der Speicher bis zur Größe der Datei)
+	first := offset / prefetchBlockSize
+	last := (end + p.ahead - 1) / prefetchBlockSize
+	for nr := range p.blocks {
+		if nr < first || nr > last {
+			delete(p.blocks, nr)
+		}
+	}
+
+	// bei sequentiellem Zugriff die nächsten Blöcke anstoßen
+	if p.seqReads >= prefetchMinSeqReads {
+		for nr := first; nr <= last && nr*prefetchBlockSize < p.size; nr++ {
+			if _, found := p.blocks[nr]; !found {
+				p.blocks[nr] = p.start(nr)
+			}
+		}
+	}
+
+	// sind alle benötigten Blöcke vorhanden?
+	var needed []*prefetchBlock
+	for nr := first; nr*prefetchBlockSize < end; nr++ {
+		b, found := p.blocks[nr]
+		if !found {
+			p.mux.Unlock()
+			return nil, false
+		}
+		needed = append(needed, b)
+	}
+	p.mux.Unlock()
+
+	// auf die Blöcke warten und die Daten zusammen kopieren
+	data = make([]byte, 0, end-offset)
+	for i, b := range needed {
+		<-b.done
+		if b.err != nil {
+			debug(p.debug, "prefetch: "+b.err.Error())
+			p.drop(first + int64(i))
+			return nil, false
+		}
+		blockStart := (first + int64(i)) * prefetchBlockSize
+		from := offset + int64(len(data)) - blockStart
+		to := end - blockStart
+		if to > int64(len(b.data)) {
+			to = int64(len(b.data))
+		}
+		data = append(data, b.data[from:to]...)
+	}
+	return data, true
+}
+
+// start holt einen Block im Hintergrund.
+// ACHTUNG: p.mux muss gesperrt sein!
+func (p *prefetcher) start(nr int64) *prefetchBlock {
+	b := &prefetchBlock{done: make(chan struct{})}
+
+	// ein Block ist immer voll, außer am Ende der Datei
+	size := p.size - nr*prefetchBlockSize
+	if size > prefetchBlockSize {
+		size = prefetchBlockSize
+	}
+
+	go func() {
+		buf := make([]byte, size)
+		n, err := p.fetch(buf, nr*prefetchBlockSize)
+		if err == nil && int64(n) != size {
+			err = fmt.Errorf("short read of block %d: %d bytes", nr, n)
+		}
+		b.data = buf[:n]
+		b.err = err
+		close(b.done)
+	}()
+
+	debug(p.debug, fmt.Sprintf("prefetch block %d", nr))
+	return b
+}
+
+// drop löscht einen fehlerhaften Block, damit er beim nächsten Mal neu geholt wird.
+func (p *prefetcher) drop(nr int64) {
+	p.mux.Lock()
+	delete(p.blocks, nr)
+	p.mux.Unlock()
+}
+
+// release gibt alle Blöcke frei. Laufende Goroutinen beenden sich von selbst.
+func (p *prefetcher) release() {
+	p.mux.Lock()
+	p.blocks = make(map[int64]*prefetchBlock)
+	p.mux.Unlock()
+}
@@ -0,0 +1,94 @@
+package fuse
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// Prüft, ob der Read-Ahead bei sequentiellem Lesen die richtigen Daten liefert
+func TestPrefetcherSequential(t *testing.T) {
+	data := make([]byte, 3*prefetchBlockSize+12345)
+	rand.Read(data)
+
+	var mux sync.Mutex
+	fetches := 0
+	fetch := func(buf []byte, offset int64) (int, error) {
+		mux.Lock()
+		fetches++
+		mux.Unlock()
+		return copy(buf, data[offset:]), nil
+	}
+	p := newPrefetcher(int64(len(data)), 2*prefetchBlockSize, fetch, false)
+
+	// der erste Read ist noch nicht sequentiell
+	if _, ok := p.read(0, 131072); ok {
+		t.Errorf("first read should not be prefetched")
+	}
+
+	// ab jetzt kommt alles aus dem Read-Ahead
+	var offset int64 = 131072
+	for offset < int64(len(data)) {
+		got, ok := p.read(offset, 131072)
+		if !ok {
+			t.Fatalf("read at %d not prefetched", offset)
+		}
+		end := offset + 131072
+		if end > int64(len(data)) {
+			end = int64(len(data))
+		}
+		if !bytes.Equal(got, data[offset:end]) {
+			t.Fatalf("wrong data at %d", offset)
+		}
+		offset = end
+	}
+
+	// jeder Block wurde genau einmal geholt
+	if fetches != 4 {
+		t.Errorf("fetch count: %d", fetches)
+	}
+
+	// hinter der Datei gibt es nichts
+	if _, ok := p.read(int64(len(data)), 4096); ok {
+		t.Errorf("read behind EOF should not be prefetched")
+	}
+}
+
+// Bei einem Fehler muss der Aufrufer selbst lesen
+func TestPrefetcherError(t *testing.T) {
+	fetch := func(buf []byte, offset int64) (int, error) {
+		return 0, errors.New("broken")
+	}
+	p := newPrefetcher(10*prefetchBlockSize, prefetchBlockSize, fetch, false)
+	for i := int64(0); i < 5; i++ {
+		if _, ok := p.read(i*4096, 4096); ok {
+			t.Errorf("read %d should fail", i)
+		}
+	}
+}
+
+// Nach einem Sprung zurück werden die Blöcke vor dem Fenster freigegeben
+func TestPrefetcherSeekBack(t *testing.T) {
+	data := make([]byte, 20*prefetchBlockSize)
+	fetch := func(buf []byte, offset int64) (int, error) {
+		return copy(buf, data[offset:]), nil
+	}
+	p := newPrefetcher(int64(len(data)), 2*prefetchBlockSize, fetch, false)
+
+	// immer weiter vorne ein Stück sequentiell lesen
+	for _, start := range []int64{15, 10, 5, 0} {
+		offset := start * prefetchBlockSize
+		for i := 0; i < 4; i++ {
+			p.read(offset, 131072)
+			offset += 131072
+		}
+	}
+	p.mux.Lock()
+	n := len(p.blocks)
+	p.mux.Unlock()
+	if n > 3 {
+		t.Errorf("too many blocks after seeking back: %d", n)
+	}
+}
@@ -15,7 +15,8 @@ import (
 // und stellt die Read() Funktion zur verfügung..
 type ReverseFile struct {
 	path     string
-	chunkNr  int
+	offset   int64 // Start des Chunks in der Klartext Datei
+	size     int64 // Größe des Chunks
 	chunkKey []byte
 	debug    bool
 	nodefs.File
@@ -42,8 +43,16 @@ func (f *ReverseFile) Read(buf []byte, chunkOffset int64) (fuse.ReadResult, fuse
 	}
 	defer fh.Close()
 
+	// nicht über das Chunkende hinaus lesen
+	if chunkOffset >= f.size {
+		return fuse.ReadResultData([]byte{}), fuse.OK
+	}
+	if int64(len(buf)) > f.size-chunkOffset {
+		buf = buf[:f.size-chunkOffset]
+	}
+
 	// offset setzen
-	offset := chunkOffset + int64(f.chunkNr)*core.CHUNKSIZE
+	offset := chunkOffset + f.offset
 	if _, err := fh.Seek(offset, 0); err != nil {
 		debug(f.debug, "can't seek: "+err.Error())
 		return fuse.ReadResultData([]byte{}), fuse.EIO
@@ -125,7 +134,6 @@ func (fs *ReverseFs) Open(name string, flags uint32, context *fuse.Context) (fil
 		return nil, fuse.ENOENT
 	}
 	relpath := pai.Path
-	chunkNr := pai.Index
 	chunkKey := pai.ChunkKey
 
 	// Hier prüfen wir, ob die Klartextdatei auf der Festplatte existiert
@@ -140,7 +148,8 @@ func (fs *ReverseFs) Open(name string, flags uint32, context *fuse.Context) (fil
 	return &ReverseFile{
 		File:     nodefs.NewDefaultFile(),
 		path:     path,
-		chunkNr:  chunkNr,
+		offset:   int64(pai.Offset),
+		size:     int64(pai.ChunkSize),
 		chunkKey: chunkKey,
 		debug:    fs.debug,
 	}, fuse.OK
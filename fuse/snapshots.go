@@ -0,0 +1,129 @@
+package fuse
+
+import (
+	"container/list"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SchnorcherSepp/splitfuse/core"
+)
+
+// Im Root-Ordner erscheint dieser Ordner mit allen Generationen der DB (nur lesen).
+// Ein echter Ordner mit diesem Namen im Root wird dadurch verdeckt (und nicht mehr aufgelistet).
+const snapshotDir = ".snapshots"
+
+// So viele Generationen werden höchstens gleichzeitig im Speicher gehalten.
+const maxSnapshotCache = 4
+
+// snapshots lädt die Generationen einer DB bei Bedarf und hält sie im Speicher.
+// Eine Generation wird nie verändert, darum muss sie nicht neu geladen werden.
+type snapshots struct {
+	debug  bool
+	dbpath string
+	key    []byte
+
+	mux    sync.Mutex
+	cache  map[string]*list.Element // Name der Generation -> *snapshot
+	lru    *list.List               // vorne: zuletzt benutzt
+	list   core.SfFile              // der Ordner .snapshots (siehe folder)
+	listed int64                    // wann der Ordner zuletzt gelesen wurde (Unix Time, 0: neu lesen)
+}
+
+// eine geladene Generation
+type snapshot struct {
+	gen string
+	db  core.SfDb
+}
+
+func newSnapshots(dbpath string, key []byte, debug bool) *snapshots {
+	return &snapshots{
+		debug:  debug,
+		dbpath: dbpath,
+		key:    key,
+		cache:  make(map[string]*list.Element),
+		lru:    list.New(),
+	}
+}
+
+// split teilt einen FUSE-Pfad unterhalb von .snapshots in den Namen der Generation und den Pfad in deren DB.
+// ok ist false, wenn der Pfad nicht in .snapshots liegt.
+func (s *snapshots) split(name string) (gen string, dbName string, ok bool) {
+	if name == snapshotDir {
+		return "", ".", true
+	}
+	if !strings.HasPrefix(name, snapshotDir+"/") {
+		return "", "", false
+	}
+	rest := name[len(snapshotDir)+1:]
+	if i := strings.Index(rest, "/"); i >= 0 {
+		return rest[:i], rest[i+1:], true
+	}
+	return rest, ".", true
+}
+
+// folder gibt den Ordner .snapshots mit allen Generationen als Unterordner zurück.
+// Der Ordner mit den Generationen wird höchstens alle intervall Sekunden neu gelesen (wie die DB).
+func (s *snapshots) folder(intervall int64) core.SfFile {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	now := time.Now().Unix()
+	if s.listed > 0 && s.listed+intervall > now {
+		return s.list
+	}
+
+	gens, err := core.ListGenerations(s.dbpath)
+	if err != nil {
+		debug(s.debug, "ERROR: list generations: "+err.Error())
+	}
+	f := core.SfFile{}
+	for _, g := range gens {
+		f.FolderContent = append(f.FolderContent, core.FolderContent{Name: g.Name})
+		f.Mtime = uint64(g.Time.Unix())
+	}
+	s.list = f
+	s.listed = now
+	return f
+}
+
+// reset sorgt dafür, dass der Ordner mit den Generationen beim nächsten Zugriff neu gelesen wird
+// (z.B. nach einem Update der DB, dabei ist meist auch eine neue Generation dazu gekommen).
+func (s *snapshots) reset() {
+	s.mux.Lock()
+	s.listed = 0
+	s.mux.Unlock()
+}
+
+// load gibt die DB einer Generation zurück.
+func (s *snapshots) load(gen string) (core.SfDb, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if el, ok := s.cache[gen]; ok {
+		s.lru.MoveToFront(el)
+		return el.Value.(*snapshot).db, nil
+	}
+
+	path, err := core.GenerationPath(s.dbpath, gen)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, err // ReadDbFile würde eine leere DB liefern
+	}
+	db, _, err := core.ReadDbFile(path, s.key)
+	if err != nil {
+		return nil, err
+	}
+
+	// merken, ist der Cache voll, dann fällt die am längsten nicht benutzte Generation heraus
+	s.cache[gen] = s.lru.PushFront(&snapshot{gen: gen, db: db})
+	for s.lru.Len() > maxSnapshotCache {
+		old := s.lru.Remove(s.lru.Back()).(*snapshot)
+		delete(s.cache, old.gen)
+	}
+	debug(s.debug, "load snapshot "+gen)
+	return db, nil
+}
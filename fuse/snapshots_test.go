@@ -0,0 +1,112 @@
+package fuse
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SchnorcherSepp/splitfuse/core"
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// Die Generationen der DB erscheinen unter /.snapshots
+func TestSnapshots(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "snapshots.test")
+	defer os.RemoveAll(dir)
+	dbpath := filepath.Join(dir, "index.db")
+	k := core.KeyFile{}
+
+	// aktuelle DB: nur noch neu.txt (und ein echter Ordner .snapshots), die Generation hat noch alt.txt
+	current := core.SfDb{
+		".":          core.SfFile{FolderContent: []core.FolderContent{{Name: "neu.txt", IsFile: true}, {Name: snapshotDir}}},
+		".snapshots": core.SfFile{},
+		"neu.txt":    core.SfFile{IsFile: true, Size: 3},
+	}
+	old := core.SfDb{
+		".":       core.SfFile{FolderContent: []core.FolderContent{{Name: "alt.txt", IsFile: true}}},
+		"alt.txt": core.SfFile{IsFile: true, Size: 7},
+	}
+	g, err := core.SaveGeneration(dbpath, k.DbKey(), core.NewDbHeader(), old, time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs := &SplitFs{dbpath: dbpath, intervall: 3600, lastDbUpdate: time.Now().Unix()}
+	fs.db.Store(current)
+	fs.snapshots = newSnapshots(dbpath, k.DbKey(), false)
+
+	// Root enthält .snapshots
+	entries, status := fs.OpenDir("", nil)
+	if status != fuse.OK || len(entries) != 2 || entries[1].Name != snapshotDir {
+		t.Errorf("root: %v, %v", entries, status)
+	}
+
+	// .snapshots enthält die Generation
+	entries, status = fs.OpenDir(snapshotDir, nil)
+	if status != fuse.OK || len(entries) != 1 || entries[0].Name != g.Name {
+		t.Errorf(".snapshots: %v, %v", entries, status)
+	}
+
+	// alte Datei in der Generation
+	attr, status := fs.GetAttr(snapshotDir+"/"+g.Name+"/alt.txt", nil)
+	if status != fuse.OK || attr.Size != 7 {
+		t.Errorf("alt.txt: %v, %v", attr, status)
+	}
+	if _, status := fs.GetAttr(snapshotDir+"/"+g.Name+"/neu.txt", nil); status != fuse.ENOENT {
+		t.Errorf("neu.txt should not exist in the snapshot: %v", status)
+	}
+	if _, status := fs.GetAttr(snapshotDir+"/20260101T000000.000Z", nil); status != fuse.ENOENT {
+		t.Errorf("unknown snapshot should not exist: %v", status)
+	}
+
+	// eine neue Generation erscheint erst nach dem Intervall (oder einem Update der DB)
+	core.SaveGeneration(dbpath, k.DbKey(), core.NewDbHeader(), old, time.Date(2026, 10, 2, 12, 0, 0, 0, time.UTC))
+	if entries, _ = fs.OpenDir(snapshotDir, nil); len(entries) != 1 {
+		t.Errorf("generations should be cached: %v", entries)
+	}
+	fs.snapshots.reset()
+	if entries, _ = fs.OpenDir(snapshotDir, nil); len(entries) != 2 {
+		t.Errorf("new generation not listed: %v", entries)
+	}
+
+	// die aktuelle DB darf nicht verändert werden
+	if len(fs.getDb()["."].FolderContent) != 2 {
+		t.Errorf("current db was modified")
+	}
+}
+
+// Der Cache behält die zuletzt benutzten Generationen
+func TestSnapshotsCache(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "snapshots.test")
+	defer os.RemoveAll(dir)
+	dbpath := filepath.Join(dir, "index.db")
+	k := core.KeyFile{}
+	s := newSnapshots(dbpath, k.DbKey(), false)
+
+	var names []string
+	for i := 0; i <= maxSnapshotCache; i++ {
+		g, err := core.SaveGeneration(dbpath, k.DbKey(), core.NewDbHeader(), core.SfDb{}, time.Date(2026, 10, 1, i, 0, 0, 0, time.UTC))
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, g.Name)
+	}
+
+	// die erste Generation wird immer wieder benutzt und darf nicht herausfallen
+	for _, n := range names {
+		if _, err := s.load(names[0]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.load(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := s.cache[names[0]]; !ok || len(s.cache) != maxSnapshotCache || s.lru.Len() != maxSnapshotCache {
+		t.Errorf("wrong cache: %d entries", len(s.cache))
+	}
+	if _, ok := s.cache[names[1]]; ok {
+		t.Errorf("least recently used generation should be dropped")
+	}
+}
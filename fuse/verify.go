@@ -0,0 +1,206 @@
+package fuse
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/SchnorcherSepp/splitfuse/core"
+)
+
+const (
+	// So viele geprüfte Chunks (mit ihren Block-Hashes) merkt sich der chunkVerifier höchstens.
+	maxVerifiedChunks = 4096
+	// So viele geprüfte Blöcke (Klartext, je core.VERIFYBLOCKSIZE) werden höchstens im Speicher gehalten.
+	maxVerifiedBlocks = 8
+)
+
+// chunkVerifier prüft Chunks vor dem ersten Lesen (--verify) und merkt sich das Ergebnis für alle Dateien.
+// Dabei wird für jeden Block des Chunks ein Hash gespeichert. Alle Daten, die danach zurück gegeben werden,
+// werden blockweise gelesen und gegen diese Hashes geprüft. So kommt auch ein Chunk, der erst nach der ersten
+// Prüfung beschädigt wurde (z.B. im Cache), nie ungeprüft zurück.
+// Jeder Chunk wird nur einmal gleichzeitig geprüft. Bei Lesefehlern wird beim nächsten Zugriff neu geprüft,
+// ein beschädigter Chunk bleibt dagegen gesperrt (bis er aus dem LRU fällt). Liegt ein Cache vor dem Backend,
+// dann wird die beschädigte Kopie verworfen und der Chunk einmal neu vom Backend geprüft. Das Ergebnis wird
+// in diesem Fall nicht gespeichert, ein Fehler im Cache sperrt den Chunk also nie dauerhaft.
+type chunkVerifier struct {
+	debug bool
+	store core.ChunkStore
+
+	mux       sync.Mutex
+	results   map[core.ChunkHash]*list.Element // -> *verifyResult
+	resultLRU *list.List                       // vorne: zuletzt benutzt
+	blocks    map[blockID]*list.Element        // -> *verifiedBlock
+	blocksLRU *list.List                       // vorne: zuletzt benutzt
+}
+
+// Ergebnis der Prüfung eines ganzen Chunks
+type verifyResult struct {
+	h      core.ChunkHash
+	once   sync.Once
+	blocks []core.BlockHash
+	err    error
+}
+
+// ein Block eines Chunks
+type blockID struct {
+	h  core.ChunkHash
+	nr int64
+}
+
+// ein geprüfter Block (Klartext), wird nach dem Speichern nie mehr verändert
+type verifiedBlock struct {
+	id   blockID
+	data []byte
+}
+
+// chunkDropper wird von Stores mit Cache implementiert (core.CacheChunkStore).
+type chunkDropper interface {
+	DropChunk(chunkName []byte)
+}
+
+func newChunkVerifier(store core.ChunkStore, debug bool) *chunkVerifier {
+	return &chunkVerifier{
+		debug:     debug,
+		store:     store,
+		results:   make(map[core.ChunkHash]*list.Element),
+		resultLRU: list.New(),
+		blocks:    make(map[blockID]*list.Element),
+		blocksLRU: list.New(),
+	}
+}
+
+// verify prüft den ganzen Chunk (nur beim ersten Zugriff) und gibt die Hashes der Blöcke zurück.
+func (v *chunkVerifier) verify(chunkName []byte, chunkKey []byte, h core.ChunkHash, size uint64) ([]core.BlockHash, error) {
+	v.mux.Lock()
+	var r *verifyResult
+	if el, ok := v.results[h]; ok {
+		v.resultLRU.MoveToFront(el)
+		r = el.Value.(*verifyResult)
+	} else {
+		r = &verifyResult{h: h}
+		v.results[h] = v.resultLRU.PushFront(r)
+		for v.resultLRU.Len() > maxVerifiedChunks {
+			old := v.resultLRU.Remove(v.resultLRU.Back()).(*verifyResult)
+			delete(v.results, old.h)
+		}
+	}
+	v.mux.Unlock()
+
+	r.once.Do(func() {
+		debug(v.debug, fmt.Sprintf("verify chunk %x", chunkName))
+		r.blocks, r.err = core.VerifyChunkBlocks(v.store, chunkName, chunkKey, h, size)
+		if core.IsChunkCorrupt(r.err) && v.drop(chunkName) {
+			// die Kopie im Cache ist verworfen: noch einmal prüfen, die Daten kommen jetzt vom Backend
+			debug(v.debug, fmt.Sprintf("ERROR: verify chunk %x: %s (verify again)", chunkName, r.err.Error()))
+			r.blocks, r.err = core.VerifyChunkBlocks(v.store, chunkName, chunkKey, h, size)
+		}
+		if core.IsChunkCorrupt(r.err) {
+			debug(v.debug, fmt.Sprintf("ERROR: verify chunk %x: %s", chunkName, r.err.Error()))
+			v.drop(chunkName)
+		}
+	})
+
+	// Lesefehler nicht speichern, damit es beim nächsten Mal neu versucht wird
+	// (mit Cache auch keinen beschädigten Chunk, die Kopie im Cache ist ja schon verworfen)
+	if r.err != nil && (!core.IsChunkCorrupt(r.err) || v.cached()) {
+		v.forget(r)
+	}
+	return r.blocks, r.err
+}
+
+// read liest ab offset aus dem Chunk und gibt nur geprüfte (und schon entschlüsselte) Daten zurück.
+func (v *chunkVerifier) read(chunkName []byte, chunkKey []byte, h core.ChunkHash, size uint64, offset int64, buf []byte) (int, error) {
+	hashes, err := v.verify(chunkName, chunkKey, h, size)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for n < len(buf) && offset+int64(n) < int64(size) {
+		pos := offset + int64(n)
+		data, err := v.block(chunkName, chunkKey, h, size, hashes, pos/core.VERIFYBLOCKSIZE)
+		if err != nil {
+			return n, err
+		}
+		n += copy(buf[n:], data[pos%core.VERIFYBLOCKSIZE:])
+	}
+	if n < len(buf) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// block gibt einen geprüften Block zurück (aus dem Speicher oder neu gelesen und gegen seinen Hash geprüft).
+func (v *chunkVerifier) block(chunkName []byte, chunkKey []byte, h core.ChunkHash, size uint64, hashes []core.BlockHash, nr int64) ([]byte, error) {
+	id := blockID{h: h, nr: nr}
+
+	// schon geprüft?
+	v.mux.Lock()
+	if el, ok := v.blocks[id]; ok {
+		v.blocksLRU.MoveToFront(el)
+		v.mux.Unlock()
+		return el.Value.(*verifiedBlock).data, nil
+	}
+	v.mux.Unlock()
+
+	// Block lesen und prüfen
+	offset := nr * core.VERIFYBLOCKSIZE
+	want := int64(size) - offset
+	if want > core.VERIFYBLOCKSIZE {
+		want = core.VERIFYBLOCKSIZE
+	}
+	data := make([]byte, want)
+	m, err := v.store.ReadChunk(chunkName, offset, data)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	core.CryptBytes(data[:m], offset, chunkKey)
+	if int64(m) != want || nr >= int64(len(hashes)) || sha256.Sum256(data) != hashes[nr] {
+		// der Chunk hat sich seit der Prüfung verändert: Cache verwerfen, beim nächsten Mal neu holen
+		err := &core.ChunkCorruptError{Reason: fmt.Sprintf("block %d changed since verification", nr)}
+		debug(v.debug, fmt.Sprintf("ERROR: verify chunk %x: %s", chunkName, err.Error()))
+		v.drop(chunkName)
+		return nil, err
+	}
+
+	// merken
+	v.mux.Lock()
+	if _, ok := v.blocks[id]; !ok {
+		v.blocks[id] = v.blocksLRU.PushFront(&verifiedBlock{id: id, data: data})
+		for v.blocksLRU.Len() > maxVerifiedBlocks {
+			old := v.blocksLRU.Remove(v.blocksLRU.Back()).(*verifiedBlock)
+			delete(v.blocks, old.id)
+		}
+	}
+	v.mux.Unlock()
+	return data, nil
+}
+
+// forget löscht das Ergebnis einer Prüfung, damit beim nächsten Zugriff neu geprüft wird.
+func (v *chunkVerifier) forget(r *verifyResult) {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	if el, ok := v.results[r.h]; ok && el.Value.(*verifyResult) == r {
+		v.resultLRU.Remove(el)
+		delete(v.results, r.h)
+	}
+}
+
+// drop verwirft einen beschädigten Chunk im Cache des Stores.
+// Gibt es keinen Cache, dann wird false zurück gegeben.
+func (v *chunkVerifier) drop(chunkName []byte) bool {
+	d, ok := v.store.(chunkDropper)
+	if ok {
+		d.DropChunk(chunkName)
+	}
+	return ok
+}
+
+// cached ist true, wenn der Store einen Cache hat (siehe drop).
+func (v *chunkVerifier) cached() bool {
+	_, ok := v.store.(chunkDropper)
+	return ok
+}
@@ -0,0 +1,127 @@
+package fuse
+
+import (
+	"bytes"
+	"container/list"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SchnorcherSepp/splitfuse/core"
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// droppingStore zählt die Aufrufe von DropChunk (wie core.CacheChunkStore)
+type droppingStore struct {
+	core.ChunkStore
+	drops int
+}
+
+func (s *droppingStore) DropChunk(chunkName []byte) {
+	s.drops++
+}
+
+// Daten, die sich nach der ersten Prüfung verändern, dürfen nicht zurück gegeben werden
+func TestVerifyChangedBlock(t *testing.T) {
+	k := core.LoadKeyfile("../testdata/test.keyfile")
+
+	// eine Datei mit einem Chunk aus drei Blöcken
+	rootdir, _ := ioutil.TempDir("", "verify.test")
+	defer os.RemoveAll(rootdir)
+	data := make([]byte, 2*core.VERIFYBLOCKSIZE+1000)
+	rand.New(rand.NewSource(5)).Read(data)
+	ioutil.WriteFile(filepath.Join(rootdir, "a.bin"), data, 0600)
+	db, _, _, _ := core.ScanFolder(rootdir, core.SfDb{}, false)
+	storedir, _ := ioutil.TempDir("", "verify.test")
+	defer os.RemoveAll(storedir)
+	dirStore, _ := core.CreateDirChunkStore(storedir)
+	if _, err := core.PushChunks(db, k, rootdir, dirStore, false); err != nil {
+		t.Fatal(err)
+	}
+	store := &droppingStore{ChunkStore: dirStore}
+
+	fs := &SplitFs{keyfile: k, store: store, verifier: newChunkVerifier(store, false)}
+	fs.db.Store(db)
+	file, _ := fs.Open("a.bin", 0, nil)
+	read := func(offset int64) ([]byte, fuse.Status) {
+		buf := make([]byte, 131072)
+		res, status := file.Read(buf, offset)
+		b, _ := res.Bytes(buf)
+		return b[:res.Size()], status
+	}
+
+	// alles in Ordnung
+	off := int64(core.VERIFYBLOCKSIZE + 500)
+	if got, status := read(off); status != fuse.OK || !bytes.Equal(got, data[off:off+131072]) {
+		t.Fatalf("read #1: %v", status)
+	}
+
+	// Chunk nach der Prüfung verändern und den geprüften Block vergessen
+	h := db["a.bin"].FileChunks[0]
+	path := dirStore.ChunkPath(k.CalcChunkCryptHash(h[:]))
+	chunk, _ := ioutil.ReadFile(path)
+	chunk[off] ^= 0xff
+	ioutil.WriteFile(path, chunk, 0600)
+	fs.verifier.blocks = make(map[blockID]*list.Element)
+	fs.verifier.blocksLRU.Init()
+
+	if _, status := read(off); status != fuse.EIO || store.drops != 1 {
+		t.Errorf("changed block: %v, drops=%d", status, store.drops)
+	}
+	if got, status := read(0); status != fuse.OK || !bytes.Equal(got, data[:131072]) {
+		t.Errorf("other block: %v", status)
+	}
+
+	// repariert: die Daten werden wieder geliefert
+	chunk[off] ^= 0xff
+	ioutil.WriteFile(path, chunk, 0600)
+	if got, status := read(off); status != fuse.OK || !bytes.Equal(got, data[off:off+131072]) {
+		t.Errorf("repaired block: %v", status)
+	}
+}
+
+// Ein beschädigter Block im Cache wird verworfen und neu vom Backend geholt
+func TestVerifyCorruptCache(t *testing.T) {
+	k := core.LoadKeyfile("../testdata/test.keyfile")
+
+	rootdir, _ := ioutil.TempDir("", "verify.test")
+	defer os.RemoveAll(rootdir)
+	data := make([]byte, core.VERIFYBLOCKSIZE+1000)
+	rand.New(rand.NewSource(6)).Read(data)
+	ioutil.WriteFile(filepath.Join(rootdir, "a.bin"), data, 0600)
+	db, _, _, _ := core.ScanFolder(rootdir, core.SfDb{}, false)
+	storedir, _ := ioutil.TempDir("", "verify.test")
+	defer os.RemoveAll(storedir)
+	dirStore, _ := core.CreateDirChunkStore(storedir)
+	if _, err := core.PushChunks(db, k, rootdir, dirStore, false); err != nil {
+		t.Fatal(err)
+	}
+	cachedir, _ := ioutil.TempDir("", "verify.test")
+	defer os.RemoveAll(cachedir)
+	cache, err := core.NewCacheChunkStore(dirStore, cachedir, 1<<30)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Chunk in den Cache holen und dort beschädigen
+	chunkName := k.CalcChunkCryptHash(db["a.bin"].FileChunks[0][:])
+	cache.ReadChunk(chunkName, 0, make([]byte, len(data)))
+	files, _ := filepath.Glob(filepath.Join(cachedir, "*", "*.0"))
+	if len(files) != 1 {
+		t.Fatalf("cache block not found: %v", files)
+	}
+	block, _ := ioutil.ReadFile(files[0])
+	block[100] ^= 0xff
+	ioutil.WriteFile(files[0], block, 0600)
+
+	fs := &SplitFs{keyfile: k, store: cache, verifier: newChunkVerifier(cache, false)}
+	fs.db.Store(db)
+	file, _ := fs.Open("a.bin", 0, nil)
+	buf := make([]byte, 4096)
+	res, status := file.Read(buf, 0)
+	if b, _ := res.Bytes(buf); status != fuse.OK || !bytes.Equal(b[:res.Size()], data[:4096]) {
+		t.Errorf("corrupt cache block was not fetched again: %v", status)
+	}
+}
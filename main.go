@@ -2,30 +2,115 @@ package main
 
 import (
 	"os"
+	"bytes"
+	"fmt"
+	"time"
 	"path/filepath"
+	"os/signal"
+	"syscall"
 
 	"github.com/SchnorcherSepp/splitfuse/core"
 	"github.com/SchnorcherSepp/splitfuse/fuse"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
+// Exit-Codes von scan --tolerant (und push)
+const (
+	exitScanFailed  = 1 // der Scan ist fehlgeschlagen, die DB wurde nicht verändert
+	exitScanPartial = 3 // die DB wurde aktualisiert, aber einzelne Elemente konnten nicht gescannt werden (push: gelesen werden)
+)
+
 var (
-	app   = kingpin.New(filepath.Base(os.Args[0]), "Ein Kommandozeilen-Tool zum Verwalten und Mounten von SplitFUSE.")
-	debug = app.Flag("debug", "Aktiviert den Debug-Mode bei FUSE").Bool()
+	app       = kingpin.New(filepath.Base(os.Args[0]), "Ein Kommandozeilen-Tool zum Verwalten und Mounten von SplitFUSE.")
+	debug     = app.Flag("debug", "Aktiviert den Debug-Mode bei FUSE").Bool()
+	showProg  = app.Flag("progress", "Zeigt den Fortschritt von scan, push und restore auf stderr (am Terminal als Balken, sonst als JSON Zeilen)").Bool()
+	progEvery = app.Flag("progress-interval", "So oft wird der Fortschritt als JSON Zeile ausgegeben (nicht am Terminal)").Default("10s").Duration()
+	pwFile    = app.Flag("passphrase-file", "Datei mit der Passphrase für ein geschütztes Keyfile (sonst SPLITFUSE_PASSPHRASE oder Eingabe am Terminal)").Envar("SPLITFUSE_PASSPHRASE_FILE").String()
+	newPwFile = app.Flag("new-passphrase-file", "Datei mit der Passphrase für das neue Keyfile bei rekey und wrapkey (sonst SPLITFUSE_NEW_PASSPHRASE oder Eingabe am Terminal)").Envar("SPLITFUSE_NEW_PASSPHRASE_FILE").String()
 
 	gen        = app.Command("newkey", "Erstellt ein neues Keyfile für SplitFuse")
 	genKeyfile = gen.Flag("keyfile", "Pfad zum Keyfile (Datei darf noch NICHT existieren)").Required().String()
+	genProtect = gen.Flag("passphrase", "Schützt das Keyfile mit einer Passphrase").Bool()
+
+	wrap        = app.Command("wrapkey", "Schützt ein vorhandenes Keyfile mit einer (neuen) Passphrase, die Schlüssel bleiben gleich")
+	wrapKeyfile = wrap.Flag("keyfile", "Pfad zum Keyfile (wird ersetzt)").Required().ExistingFile()
+	wrapRemove  = wrap.Flag("unwrap", "Entfernt den Schutz und schreibt das Keyfile wieder ungeschützt").Bool()
 
 	scan        = app.Command("scan", "Scant einen Ordner und aktualisiert gegebebenfalls die DB")
 	scanDB      = scan.Flag("dbfile", "Pfad zur DB (wird überschrieben)").Required().String()
 	scanKeyfile = scan.Flag("keyfile", "Pfad zum Keyfile").Required().ExistingFile()
 	scanRoot    = scan.Flag("rootdir", "Pfad zum Root-Ordner mit allen Klartext Dateien").Required().ExistingDir()
+	scanBackup  = scan.Flag("backup", "Behält die vorherige DB als <dbfile>.bak").Bool()
+	scanChunkSz = scan.Flag("chunksize", "Feste Chunkgröße (vielfaches von 128KiB), wird in der DB gespeichert (Standard: 1GiB oder die Größe der DB)").Default("0").Bytes()
+	scanMode    = scan.Flag("chunking", "Aufteilung der Dateien: fixed (feste Chunkgröße, siehe --chunksize) oder cdc (content-defined chunking)").Default(core.ChunkingFixed).Enum(core.ChunkingFixed, core.ChunkingCDC)
+	scanCdcMin  = scan.Flag("cdc-min", "cdc: minimale Chunkgröße").Default("16MB").Bytes()
+	scanCdcAvg  = scan.Flag("cdc-avg", "cdc: durchschnittliche Chunkgröße (Zweierpotenz)").Default("64MB").Bytes()
+	scanCdcMax  = scan.Flag("cdc-max", "cdc: maximale Chunkgröße").Default("256MB").Bytes()
+	scanGens    = scan.Flag("generations", "Speichert jede neue DB zusätzlich in <dbfile>.generations und behält die letzten N (0: aus)").Default("0").Int()
+	scanExclude = scan.Flag("exclude", "Überspringt Elemente, die auf das Muster passen (wie .gitignore, mehrfach möglich, zusätzlich zu "+core.IgnoreFileName+")").Strings()
+	scanTolerat = scan.Flag("tolerant", "Fehler bei einzelnen Dateien brechen den Scan nicht ab (Exit-Code 3: DB aktualisiert, aber nicht vollständig gescannt)").Bool()
+	scanRetries = scan.Flag("retries", "So oft wird eine Datei neu gescannt, die sich während dem Scan verändert").Default("3").Int()
+	scanCheckpt = scan.Flag("checkpoint", "So oft werden die gehashten Dateien in <dbfile>.checkpoint gespeichert, ein abgebrochener Scan wird damit fortgesetzt (0: aus)").Default("5m").Duration()
+	scanJobs    = scan.Flag("jobs", "So viele Chunks werden gleichzeitig gehasht (auch innerhalb einer großen Datei)").Default("1").Int()
+
+	watch        = app.Command("watch", "Beobachtet einen Ordner (inotify) und aktualisiert die DB laufend")
+	watchDB      = watch.Flag("dbfile", "Pfad zur DB (wird überschrieben)").Required().String()
+	watchKeyfile = watch.Flag("keyfile", "Pfad zum Keyfile").Required().ExistingFile()
+	watchRoot    = watch.Flag("rootdir", "Pfad zum Root-Ordner mit allen Klartext Dateien").Required().ExistingDir()
+	watchBackup  = watch.Flag("backup", "Behält die vorherige DB als <dbfile>.bak").Bool()
+	watchMode    = watch.Flag("chunking", "Aufteilung neuer Dateien: fixed (Chunkgröße der DB) oder cdc (mit den Standardgrößen)").Default(core.ChunkingFixed).Enum(core.ChunkingFixed, core.ChunkingCDC)
+	watchGens    = watch.Flag("generations", "Speichert jede neue DB zusätzlich in <dbfile>.generations und behält die letzten N (0: aus)").Default("0").Int()
+	watchExclude = watch.Flag("exclude", "Überspringt Elemente, die auf das Muster passen (wie .gitignore, mehrfach möglich, zusätzlich zu "+core.IgnoreFileName+")").Strings()
+	watchRetries = watch.Flag("retries", "So oft wird eine Datei neu gescannt, die sich während dem Scan verändert").Default("3").Int()
+	watchJobs    = watch.Flag("jobs", "So viele Chunks werden gleichzeitig gehasht").Default("1").Int()
+	watchWait    = watch.Flag("debounce", "Nach einer Änderung wird so lange gewartet, bis gescannt wird").Default("10s").Duration()
+	watchEvery   = watch.Flag("interval", "So oft wird eine geänderte DB höchstens gespeichert").Default("5m").Duration()
 
 	normal       = app.Command("normal", "Mountet Klartext Dateien")
-	normalDB     = normal.Flag("dbfile", "Pfad zur DB. Die Datei wird regelmäßig neu eingelesen.").Required().ExistingFile()
+	normalDB     = normal.Flag("dbfile", "Pfad zur DB. Die Datei wird regelmäßig neu eingelesen.").Required().String()
 	normalKey    = normal.Flag("keyfile", "Pfad zum Keyfile").Required().ExistingFile()
-	normalChunks = normal.Flag("chunkdir", "Pfad zum Ordner mit allen notwendigen Chunks (eventuell CloudMount)").Required().ExistingDir()
+	normalChunks = normal.Flag("chunkdir", "Pfad zum Ordner mit allen notwendigen Chunks (eventuell CloudMount)").Required().String()
 	normalMount  = normal.Flag("mountdir", "Ordner, in dem die Klartext Dateien gemountet werden sollen").Required().ExistingDir()
+	normalWait   = normal.Flag("wait", "Maximale Wartezeit, bis chunkdir und dbfile verfügbar sind (z.B. rclone mount)").Default("0s").Duration()
+	normalCache  = normal.Flag("cachedir", "Lokaler Ordner für den Chunk-Cache (optional)").String()
+	normalCacheS = normal.Flag("cachesize", "Maximale Größe des Chunk-Cache (z.B. 20GB)").Default("10GB").Bytes()
+	normalVerify = normal.Flag("verify", "Prüft jeden Chunk vor dem ersten Lesen komplett und danach jeden gelesenen Block (EIO bei beschädigten Chunks)").Bool()
+	normalAhead  = normal.Flag("prefetch", "So viel wird bei sequentiellem Lesen im Voraus gelesen (0 schaltet den Read-Ahead aus)").Default("16MB").Bytes()
+	normalOwner  = normal.Flag("map-owner", "Alle Dateien gehören dem Benutzer, der mountet (statt Besitzer und Gruppe aus der DB)").Bool()
+	normalSnaps  = normal.Flag("snapshots", "Blendet die Generationen der DB (scan --generations) unter /.snapshots ein").Bool()
+
+	push        = app.Command("push", "Verschlüsselt die Chunks ohne reverse mount und schreibt alle fehlenden Chunks in den Ziel-Ordner")
+	pushDB      = push.Flag("dbfile", "Pfad zur DB").Required().ExistingFile()
+	pushKeyfile = push.Flag("keyfile", "Pfad zum Keyfile").Required().ExistingFile()
+	pushRoot    = push.Flag("rootdir", "Pfad zum Root-Ordner mit allen Klartext Dateien").Required().ExistingDir()
+	pushTarget  = push.Flag("target", "Ziel-Ordner für die Chunks (die Unterordner 00 bis ff werden angelegt)").Required().String()
+
+	verify         = app.Command("verify", "Prüft, ob alle Chunks der DB im Chunk-Ordner vorhanden und in Ordnung sind")
+	verifyDB       = verify.Flag("dbfile", "Pfad zur DB").Required().ExistingFile()
+	verifyKeyfile  = verify.Flag("keyfile", "Pfad zum Keyfile").Required().ExistingFile()
+	verifyChunkdir = verify.Flag("chunkdir", "Pfad zum Ordner mit allen Chunks").Required().ExistingDir()
+	verifyDeep     = verify.Flag("deep", "Entschlüsselt jeden Chunk und prüft den Hash über den Klartext").Bool()
+
+	gc         = app.Command("gc", "Sucht verwaiste Chunks, die von keiner DB mehr verwendet werden")
+	gcDB       = gc.Flag("dbfile", "Pfad zu einer DB (kann mehrfach angegeben werden)").Required().ExistingFiles()
+	gcKeyfile  = gc.Flag("keyfile", "Pfad zum Keyfile").Required().ExistingFile()
+	gcChunkdir = gc.Flag("chunkdir", "Pfad zum Ordner mit allen Chunks").Required().ExistingDir()
+	gcDelete   = gc.Flag("delete", "Löscht die verwaisten Chunks").Bool()
+	gcGrace    = gc.Flag("grace", "Nur Chunks löschen, die älter sind (mtime)").Default("168h").Duration()
+
+	restore         = app.Command("restore", "Stellt die Klartext Dateien ohne FUSE aus den Chunks wieder her (vorhandene Dateien mit gleicher Größe und mtime werden ohne Prüfung übersprungen)")
+	restoreDB       = restore.Flag("dbfile", "Pfad zur DB").Required().ExistingFile()
+	restoreKeyfile  = restore.Flag("keyfile", "Pfad zum Keyfile").Required().ExistingFile()
+	restoreChunkdir = restore.Flag("chunkdir", "Pfad zum Ordner mit allen Chunks").Required().ExistingDir()
+	restoreTarget   = restore.Flag("target", "Ziel-Ordner für die Klartext Dateien").Required().String()
+	restorePath     = restore.Flag("path", "Nur diesen Unterordner oder diese Datei wiederherstellen (relativer Pfad)").Default(".").String()
+
+	rekey         = app.Command("rekey", "Verschlüsselt DB, Generationen und Chunks mit einem neuen Keyfile (kann nach einem Abbruch wiederholt werden)")
+	rekeyOldKey   = rekey.Flag("old-keyfile", "Pfad zum alten Keyfile").Required().ExistingFile()
+	rekeyNewKey   = rekey.Flag("new-keyfile", "Pfad zum neuen Keyfile (newkey)").Required().ExistingFile()
+	rekeyDB       = rekey.Flag("dbfile", "Pfad zur DB (wird am Ende mit dem neuen Keyfile überschrieben, <dbfile>.bak und der Checkpoint werden gelöscht)").Required().ExistingFile()
+	rekeyChunkdir = rekey.Flag("chunkdir", "Pfad zum Ordner mit allen Chunks").Required().ExistingDir()
+	rekeyDelete   = rekey.Flag("delete", "Löscht die alten Chunks, wenn die DB umgestellt ist").Bool()
 
 	reverse      = app.Command("reverse", "Mountet den Chunk-Ordner um die Chunks mit der Cloud syncronisieren zu können")
 	reverseDB    = reverse.Flag("dbfile", "Pfad zur DB").Required().ExistingFile()
@@ -37,40 +122,342 @@ var (
 func main() {
 	app.Version("splitfuse 2.2.1")
 	command := kingpin.MustParse(app.Parse(os.Args[1:]))
+	core.PassphraseFile = *pwFile
+	core.NewPassphraseFile = *newPwFile
 
 	switch command {
 	case gen.FullCommand():
 		// neues keyfile schreiben
-		core.NewRandomKeyfile(*genKeyfile)
+		if *genProtect {
+			passphrase, err := core.ReadNewPassphrase()
+			if err != nil {
+				panic(err)
+			}
+			core.NewProtectedKeyfile(*genKeyfile, passphrase)
+		} else {
+			core.NewRandomKeyfile(*genKeyfile)
+		}
+
+	case wrap.FullCommand():
+		// keyfile neu schützen (oder den Schutz entfernen)
+		if err := core.RewrapKeyfile(*wrapKeyfile, !*wrapRemove); err != nil {
+			panic(err)
+		}
 
 	case scan.FullCommand():
 		// keyfile laden
 		k := core.LoadKeyfile(*scanKeyfile)
 		// alte DB laden
-		oldDB, err := core.DbFromFile(*scanDB, k.DbKey(), )
+		oldDB, hdr, err := core.ReadDbFile(*scanDB, k.DbKey())
 		if err != nil {
 			panic(err)
 		}
-		// ordern scannen
-		newDB, changed, summary, err := core.ScanFolder(*scanRoot, oldDB, *debug)
-		if err != nil {
+		// ordern scannen (ohne --chunksize bleibt die Chunkgröße der DB erhalten)
+		cfg := core.ScanConfig{
+			Jobs:      *scanJobs,
+			Exclude:   *scanExclude,
+			Tolerant:  *scanTolerat,
+			Retries:   *scanRetries,
+			Chunking:  *scanMode,
+			ChunkSize: hdr.ChunkSize,
+			MinSize:   uint64(*scanCdcMin),
+			AvgSize:   uint64(*scanCdcAvg),
+			MaxSize:   uint64(*scanCdcMax),
+		}
+		if *scanChunkSz > 0 {
+			cfg.ChunkSize = uint64(*scanChunkSz)
+		}
+		if *scanCheckpt > 0 {
+			cfg.Checkpoint = &core.CheckpointConfig{Path: core.CheckpointPath(*scanDB), Key: k.DbKey(), Interval: *scanCheckpt}
+		}
+		cfg.Progress = startProgress("scan")
+		newDB, changed, summary, err := core.ScanFolderWithConfig(*scanRoot, oldDB, cfg, *debug)
+		cfg.Progress.Stop()
+		partial, isPartial := err.(*core.PartialScanError)
+		if err != nil && !isPartial {
+			if *scanTolerat {
+				println(summary)
+				os.Exit(exitScanFailed)
+			}
 			panic(err)
 		}
+		if hdr.ChunkSize != cfg.ChunkSize {
+			changed = true
+		}
+		hdr = core.NewDbHeader()
+		hdr.ChunkSize = cfg.ChunkSize
 		// gibt es änderungen?
 		if changed {
 			print("update DB: ")
 			println(summary)
-			err = core.DbToFile(*scanDB, k.DbKey(), newDB)
+			if err := saveDB(*scanDB, k.DbKey(), hdr, newDB, *scanBackup, *scanGens); err != nil {
+				panic(err)
+			}
+		}
+		// der Scan ist fertig, der Checkpoint wird nicht mehr gebraucht
+		os.Remove(core.CheckpointPath(*scanDB))
+		// Elemente mit Fehlern ausgeben (tolerant Modus)
+		if isPartial {
+			for _, e := range partial.Errors {
+				fmt.Printf("ERROR: %s\n", e.Error())
+			}
+			println(summary)
+			os.Exit(exitScanPartial)
+		}
+
+	case watch.FullCommand():
+		// keyfile und alte DB laden
+		k := core.LoadKeyfile(*watchKeyfile)
+		db, hdr, err := core.ReadDbFile(*watchDB, k.DbKey())
+		if err != nil {
+			panic(err)
+		}
+		cfg := core.DefaultScanConfig()
+		cfg.Jobs = *watchJobs
+		cfg.Exclude = *watchExclude
+		cfg.Tolerant = true // einzelne Fehler dürfen den Dienst nicht beenden
+		cfg.Retries = *watchRetries
+		cfg.Chunking = *watchMode
+		cfg.ChunkSize = hdr.ChunkSize
+		hdr = core.NewDbHeader()
+		hdr.ChunkSize = cfg.ChunkSize
+		save := func(db core.SfDb) error {
+			println("update DB: " + *watchDB)
+			return saveDB(*watchDB, k.DbKey(), hdr, db, *watchBackup, *watchGens)
+		}
+
+		// zuerst beobachten, dann einmal alles scannen (sonst fehlen Änderungen dazwischen)
+		w, err := core.NewWatcher(*watchRoot)
+		if err != nil {
+			panic(err)
+		}
+		go func() {
+			for err := range w.Errors {
+				println("ERROR: " + err.Error())
+			}
+		}()
+
+		// bis SIGINT oder SIGTERM laufend aktualisieren
+		stop := make(chan struct{})
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			<-sig
+			close(stop)
+		}()
+		// der erste Scan läuft in WatchFolder, damit die Ereignisse dabei schon gesammelt werden
+		opts := core.WatchOptions{Debounce: *watchWait, Interval: *watchEvery, InitialScan: true}
+		if err := core.WatchFolder(*watchRoot, db, cfg, opts, w.Events, stop, save, *debug); err != nil {
+			panic(err)
+		}
+		w.Close()
+
+	case push.FullCommand():
+		// keyfile und DB laden
+		k := core.LoadKeyfile(*pushKeyfile)
+		db, err := core.DbFromFile(*pushDB, k.DbKey())
+		if err != nil {
+			panic(err)
+		}
+		// Ziel vorbereiten
+		target, err := core.CreateDirChunkStore(*pushTarget)
+		if err != nil {
+			panic(err)
+		}
+		// fehlende Chunks schreiben
+		progress := startProgress("push")
+		summary, err := core.PushChunksWithProgress(db, k, *pushRoot, target, progress, *debug)
+		progress.Stop()
+		// Dateien mit Fehlern ausgeben, alle anderen Chunks wurden geschrieben
+		if partial, ok := err.(*core.PartialPushError); ok {
+			for _, e := range partial.Errors {
+				fmt.Printf("ERROR: %s\n", e.Error())
+			}
+			println(summary)
+			os.Exit(exitScanPartial)
+		}
+		println(summary)
+		if err != nil {
+			panic(err)
+		}
+
+	case verify.FullCommand():
+		// keyfile, DB und Chunk-Ordner laden
+		k := core.LoadKeyfile(*verifyKeyfile)
+		db, err := core.DbFromFile(*verifyDB, k.DbKey())
+		if err != nil {
+			panic(err)
+		}
+		store, err := core.NewDirChunkStore(*verifyChunkdir)
+		if err != nil {
+			panic(err)
+		}
+		// prüfen
+		problems, summary, err := core.VerifyStore(db, k, store, *verifyDeep, *debug)
+		if err != nil {
+			panic(err)
+		}
+		// Probleme mit den betroffenen Dateien ausgeben
+		for _, p := range problems {
+			fmt.Printf("%x: %s\n", p.Name, p.Problem)
+			for _, path := range p.Paths {
+				fmt.Printf("    %s\n", path)
+			}
+		}
+		println(summary)
+		if len(problems) > 0 {
+			os.Exit(1)
+		}
+
+	case gc.FullCommand():
+		// keyfile und alle DBs laden (auch alle Generationen, deren Chunks werden noch gebraucht)
+		k := core.LoadKeyfile(*gcKeyfile)
+		var dbs []core.SfDb
+		skipped := 0
+		for _, path := range *gcDB {
+			paths := []string{path}
+			gens, err := core.ListGenerations(path)
 			if err != nil {
 				panic(err)
 			}
+			for _, g := range gens {
+				paths = append(paths, g.Path)
+			}
+			for i, p := range paths {
+				db, err := core.DbFromFile(p, k.DbKey())
+				if err != nil && i == 0 {
+					panic(err)
+				}
+				if err != nil {
+					// z.B. eine Generation, die noch mit einem alten Keyfile verschlüsselt ist
+					fmt.Printf("ERROR: skip generation %s: %v\n", p, err)
+					skipped++
+					continue
+				}
+				dbs = append(dbs, db)
+			}
+		}
+		// ohne die übersprungenen Generationen würden deren Chunks gelöscht
+		if skipped > 0 && *gcDelete {
+			println("gc --delete: some generations can't be read, nothing is deleted")
+			os.Exit(1)
+		}
+		store, err := core.NewDirChunkStore(*gcChunkdir)
+		if err != nil {
+			panic(err)
+		}
+		// verwaiste Chunks suchen (und löschen)
+		orphans, summary, err := core.CollectGarbage(dbs, k, store, *gcGrace, *gcDelete, *debug)
+		for _, o := range orphans {
+			fmt.Printf("%x %d %s\n", o.Name, o.Size, o.Mtime.Format(time.RFC3339))
+		}
+		println(summary)
+		if err != nil {
+			panic(err)
+		}
+
+	case restore.FullCommand():
+		// keyfile, DB und Chunk-Ordner laden
+		k := core.LoadKeyfile(*restoreKeyfile)
+		db, err := core.DbFromFile(*restoreDB, k.DbKey())
+		if err != nil {
+			panic(err)
+		}
+		store, err := core.NewDirChunkStore(*restoreChunkdir)
+		if err != nil {
+			panic(err)
+		}
+		// Dateien schreiben
+		progress := startProgress("restore")
+		summary, err := core.RestoreFilesWithProgress(db, k, store, *restoreTarget, *restorePath, progress, *debug)
+		progress.Stop()
+		println(summary)
+		if err != nil {
+			panic(err)
+		}
+
+	case rekey.FullCommand():
+		// beide keyfiles laden
+		oldK := core.LoadKeyfile(*rekeyOldKey)
+		newK := core.LoadNewKeyfile(*rekeyNewKey)
+		if bytes.Equal(oldK.DbKey(), newK.DbKey()) {
+			panic("old and new keyfile are the same")
+		}
+		store, err := core.NewDirChunkStore(*rekeyChunkdir)
+		if err != nil {
+			panic(err)
+		}
+		// Chunks, DB und Generationen umstellen (alte Chunks optional löschen)
+		summary, err := core.RekeyDbFiles(*rekeyDB, oldK, newK, store, *rekeyDelete, *debug)
+		println(summary)
+		if err != nil {
+			panic(err)
 		}
 
 	case normal.FullCommand():
-		fuse.MountNormal(*normalDB, *normalKey, *normalChunks, *normalMount, *debug, false)
+		// auf den Chunk-Ordner warten (z.B. rclone mount, der gerade erst gestartet wurde)
+		var store core.ChunkStore
+		store, err := core.WaitDirChunkStore(*normalChunks, *normalWait)
+		if err != nil {
+			panic(err)
+		}
+		// optional: lokaler Cache vor dem Chunk-Ordner
+		if *normalCache != "" {
+			store, err = core.NewCacheChunkStore(store, *normalCache, int64(*normalCacheS))
+			if err != nil {
+				panic(err)
+			}
+		}
+		// die DB liegt meistens im selben mount
+		if err := waitForFile(*normalDB, *normalWait); err != nil {
+			panic(err)
+		}
+		opts := fuse.NormalOptions{
+			Prefetch:  int64(*normalAhead),
+			Verify:    *normalVerify,
+			Snapshots: *normalSnaps,
+			MapOwner:  *normalOwner,
+		}
+		fuse.MountNormal(*normalDB, *normalKey, store, *normalMount, opts, *debug, false)
 
 	case reverse.FullCommand():
 		fuse.MountReverse(*reverseDB, *reverseKey, *reverseRoot, *reverseMount, *debug, false)
 	}
 
 }
+
+// startProgress startet die Ausgabe des Fortschritts, wenn --progress gesetzt ist (sonst nil).
+func startProgress(op string) *core.Progress {
+	if !*showProg {
+		return nil
+	}
+	return core.StartProgress(op, os.Stderr, *progEvery)
+}
+
+// saveDB schreibt die DB und speichert sie zusätzlich als Generation (wenn gens > 0, dann bleiben die letzten gens).
+func saveDB(dbpath string, key []byte, hdr core.DbHeader, db core.SfDb, backup bool, gens int) error {
+	if err := core.WriteDbFile(dbpath, key, hdr, db, backup); err != nil {
+		return err
+	}
+	if gens > 0 {
+		if _, err := core.SaveGeneration(dbpath, key, hdr, db, time.Now()); err != nil {
+			return err
+		}
+		if _, err := core.PruneGenerations(dbpath, gens); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForFile wartet maximal timeout, bis die Datei existiert.
+func waitForFile(path string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err := os.Stat(path)
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
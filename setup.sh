@@ -54,10 +54,8 @@ if [ "\$(/usr/bin/whoami)" != "$USER" ]; then
 fi
 # rclone mount
 HOME=$CONFFOLDER /usr/bin/rclone --config $RCLONECONFFILE mount readonly: $MNTRCLONE &
-# best race condition fix ever !!
-/bin/sleep 5
-# splitfuse
-/usr/bin/splitfuse normal --dbfile $MNTRCLONE/index.db --keyfile $SPLITKEYFILE --chunkdir $MNTRCLONE/partstorage --mountdir $MNTSPLIT
+# splitfuse (wartet bis zu 60s auf den rclone mount, alte DB Stände unter /.snapshots)
+/usr/bin/splitfuse normal --wait 60s --snapshots --dbfile $MNTRCLONE/index.db --keyfile $SPLITKEYFILE --chunkdir $MNTRCLONE/partstorage --mountdir $MNTSPLIT
 EOL
 chmod +x $MOUNTSCRIPT
 
@@ -84,9 +82,11 @@ fi
 /bin/mkdir -p $TMPDBFOLDER
 /bin/rm $DB &> /dev/null
 HOME=$CONFFOLDER /usr/bin/rclone --config $RCLONECONFFILE copy upload:index.db $TMPDBFOLDER/
+# alte DB Stände (Generationen) holen
+HOME=$CONFFOLDER /usr/bin/rclone --config $RCLONECONFFILE copy upload:index.db.generations $TMPDB.generations/
 OLDSTATUS="\$(/bin/ls -l $TMPDB)"
-# update DB
-/usr/bin/splitfuse scan --dbfile $TMPDB --keyfile $SPLITKEYFILE --rootdir \$ROOTDIR
+# update DB (vorherige DB als .bak, die letzten 30 Stände als Generationen behalten)
+/usr/bin/splitfuse scan --backup --generations 30 --dbfile $TMPDB --keyfile $SPLITKEYFILE --rootdir \$ROOTDIR
 NEWSTATUS="\$(/bin/ls -l $TMPDB)"
 # are there new files?
 if [ "\$OLDSTATUS" == "\$NEWSTATUS" ]; then
@@ -106,6 +106,8 @@ done
 /bin/echo "start rclone sync ..."
 HOME=$CONFFOLDER /usr/bin/rclone --config $RCLONECONFFILE copy --transfers 1 --size-only -v $REVERSEMOUNT upload:partstorage
 HOME=$CONFFOLDER /usr/bin/rclone --config $RCLONECONFFILE copy $TMPDB upload:/
+# Generationen abgleichen (gelöschte Generationen werden auch in der Cloud gelöscht)
+HOME=$CONFFOLDER /usr/bin/rclone --config $RCLONECONFFILE sync $TMPDB.generations upload:index.db.generations
 # unmount
 /bin/echo "unmount ..."
 /bin/fusermount -u $REVERSEMOUNT